@@ -3,19 +3,41 @@ package cache
 import (
 	"bytes"
 	"database/sql"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync"
+	"github.com/tschroed/spotsync/media"
 )
 
 const (
 	searchesTable = "searches"
 	searchesKey   = "query"
+	libraryTable  = "library"
+	libraryKey    = "path"
+	reviewsTable  = "reviews"
+	reviewsKey    = "album"
+	albumsTable   = "albums"
+	albumsKey     = "id"
+	stateTable    = "syncstate"
+	stateKey      = "path"
+	aliasesTable  = "aliases"
+	aliasesKey    = "artist"
+	artistsTable  = "artists"
+	artistsKey    = "artist"
 )
 
+// schema creates whichever tables are missing, so caches made by older
+// versions gain those added since.
+//
+//go:embed cache.sql
+var schema string
+
 type Cache struct {
 	db    *sql.DB
 	debug bool
@@ -30,6 +52,10 @@ func New(filename string, o Options) (*Cache, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return &Cache{
 		db:    db,
 		debug: o.Debug,
@@ -93,3 +119,274 @@ func (c *Cache) Search(search string) (*spotify.SearchResult, error) {
 	}
 	return &s, nil
 }
+
+func (c *Cache) UpsertSpotifyAlbum(a *spotify.FullAlbum) error {
+	return c.upsertAny(albumsTable, albumsKey, string(a.ID), a)
+}
+
+func (c *Cache) SpotifyAlbum(id spotify.ID) (*spotify.FullAlbum, error) {
+	var a spotify.FullAlbum
+	err := c.lookupAny(albumsTable, albumsKey, string(id), &a)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (c *Cache) deleteAny(table string, keyName string, key string) error {
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s=?", table, keyName)
+	_, err := c.db.Exec(q, key)
+	return err
+}
+
+func (c *Cache) keysAny(table string, keyName string) ([]string, error) {
+	q := fmt.Sprintf("SELECT %s FROM %s", keyName, table)
+	rows, err := c.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// UpsertAlbum, LookupAlbum, DeleteAlbum and AlbumPaths implement
+// media.Index.
+func (c *Cache) UpsertAlbum(a *media.IndexedAlbum) error {
+	return c.upsertAny(libraryTable, libraryKey, a.Path, a)
+}
+
+func (c *Cache) LookupAlbum(path string) (*media.IndexedAlbum, error) {
+	var a media.IndexedAlbum
+	err := c.lookupAny(libraryTable, libraryKey, path, &a)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (c *Cache) DeleteAlbum(path string) error {
+	return c.deleteAny(libraryTable, libraryKey, path)
+}
+
+func (c *Cache) AlbumPaths() ([]string, error) {
+	return c.keysAny(libraryTable, libraryKey)
+}
+
+// Review is a local album whose possible matches need a human to decide
+// between them. Albums synced track by track have Tracks rather than
+// Candidates.
+type Review struct {
+	Album      *media.AlbumMetadata
+	Candidates []spotify.SimpleAlbum
+	Tracks     []TrackReview
+}
+
+// TrackReview is a single track of a Review. Exact tracks need no
+// decision, but are kept so the album can be added as a whole.
+type TrackReview struct {
+	Track      media.Track
+	Candidates []spotify.FullTrack
+	Exact      bool
+}
+
+func (r *Review) key() string {
+	return r.Album.Artist + "/" + r.Album.Name
+}
+
+func (c *Cache) UpsertReview(r *Review) error {
+	return c.upsertAny(reviewsTable, reviewsKey, r.key(), r)
+}
+
+func (c *Cache) DeleteReview(r *Review) error {
+	return c.deleteAny(reviewsTable, reviewsKey, r.key())
+}
+
+// Reviews returns all queued reviews.
+func (c *Cache) Reviews() ([]*Review, error) {
+	keys, err := c.keysAny(reviewsTable, reviewsKey)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]*Review, 0, len(keys))
+	for _, k := range keys {
+		var r Review
+		if err := c.lookupAny(reviewsTable, reviewsKey, k, &r); err != nil {
+			return nil, err
+		}
+		rs = append(rs, &r)
+	}
+	return rs, nil
+}
+
+// SyncState links a local album to the Spotify album it was synced to,
+// recording when each was last seen so that removals on either side can
+// be told apart from albums never synced. States whose album has gone
+// from one side are kept as tombstones.
+type SyncState struct {
+	// Path is the local album's, as in the library index, if it has one.
+	Path      string
+	Artist    string
+	Name      string
+	SpotifyID spotify.ID
+	// LocalSeen and SpotifySeen are when the album was last found in
+	// the local library and the Spotify library respectively.
+	LocalSeen   time.Time
+	SpotifySeen time.Time
+	// LocalGone and SpotifyGone are set once the album has been found
+	// missing from that side, and cleared should it come back.
+	LocalGone   time.Time
+	SpotifyGone time.Time
+	// Strategy is the query strategy which found the album, if any.
+	Strategy string
+}
+
+// SyncKey is the key of a local album's sync state: its path, or for
+// albums without one, such as library albums with no location, its
+// canonical artist and name.
+func SyncKey(path, artist, name string) string {
+	if path != "" {
+		return path
+	}
+	return spotsync.CanonicalizeName(artist) + " / " + spotsync.CanonicalizeName(name)
+}
+
+func (st *SyncState) key() string {
+	return SyncKey(st.Path, st.Artist, st.Name)
+}
+
+func (c *Cache) UpsertSyncState(st *SyncState) error {
+	return c.upsertAny(stateTable, stateKey, st.key(), st)
+}
+
+// SyncState returns the state of the local album with the SyncKey key, or
+// an error if it has never been synced.
+func (c *Cache) SyncState(key string) (*SyncState, error) {
+	var st SyncState
+	err := c.lookupAny(stateTable, stateKey, key, &st)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *Cache) DeleteSyncState(key string) error {
+	return c.deleteAny(stateTable, stateKey, key)
+}
+
+// SyncStates returns all sync states, tombstones included.
+func (c *Cache) SyncStates() ([]*SyncState, error) {
+	keys, err := c.keysAny(stateTable, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	sts := make([]*SyncState, 0, len(keys))
+	for _, k := range keys {
+		st, err := c.SyncState(k)
+		if err != nil {
+			return nil, err
+		}
+		sts = append(sts, st)
+	}
+	return sts, nil
+}
+
+// Alias says what a local artist is called on Spotify, when that's not
+// something matching can work out: by name, or by artist ID.
+type Alias struct {
+	// Artist is the local artist's name. Aliases are keyed by its
+	// canonical form, so there's one per artist however it's written.
+	Artist string
+	Names  []string
+	IDs    []spotify.ID
+}
+
+func (a *Alias) key() string {
+	return spotsync.CanonicalizeName(a.Artist)
+}
+
+func (c *Cache) UpsertAlias(a *Alias) error {
+	return c.upsertAny(aliasesTable, aliasesKey, a.key(), a)
+}
+
+func (c *Cache) DeleteAlias(a *Alias) error {
+	return c.deleteAny(aliasesTable, aliasesKey, a.key())
+}
+
+// Aliases returns all artist aliases.
+func (c *Cache) Aliases() ([]*Alias, error) {
+	keys, err := c.keysAny(aliasesTable, aliasesKey)
+	if err != nil {
+		return nil, err
+	}
+	as := make([]*Alias, 0, len(keys))
+	for _, k := range keys {
+		var a Alias
+		if err := c.lookupAny(aliasesTable, aliasesKey, k, &a); err != nil {
+			return nil, err
+		}
+		as = append(as, &a)
+	}
+	return as, nil
+}
+
+// ArtistPin is the Spotify artist a local artist was confidently matched
+// to, so their other albums can be looked for among the artist's own.
+type ArtistPin struct {
+	// Artist is the local artist's name. Pins are keyed by its canonical
+	// form, like aliases.
+	Artist string
+	ID     spotify.ID
+	Name   string
+	// Albums is the artist's discography as of Fetched, or nil if it's
+	// yet to be fetched.
+	Albums  []spotify.SimpleAlbum
+	Fetched time.Time
+}
+
+func (p *ArtistPin) key() string {
+	return spotsync.CanonicalizeName(p.Artist)
+}
+
+func (c *Cache) UpsertArtistPin(p *ArtistPin) error {
+	return c.upsertAny(artistsTable, artistsKey, p.key(), p)
+}
+
+// ArtistPin returns the pin for the local artist, or an error if there's
+// none.
+func (c *Cache) ArtistPin(artist string) (*ArtistPin, error) {
+	var p ArtistPin
+	err := c.lookupAny(artistsTable, artistsKey, spotsync.CanonicalizeName(artist), &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (c *Cache) DeleteArtistPin(p *ArtistPin) error {
+	return c.deleteAny(artistsTable, artistsKey, p.key())
+}
+
+// ArtistPins returns all artist pins.
+func (c *Cache) ArtistPins() ([]*ArtistPin, error) {
+	keys, err := c.keysAny(artistsTable, artistsKey)
+	if err != nil {
+		return nil, err
+	}
+	ps := make([]*ArtistPin, 0, len(keys))
+	for _, k := range keys {
+		var p ArtistPin
+		if err := c.lookupAny(artistsTable, artistsKey, k, &p); err != nil {
+			return nil, err
+		}
+		ps = append(ps, &p)
+	}
+	return ps, nil
+}
@@ -1,5 +1,35 @@
-CREATE TABLE [searches] (
+CREATE TABLE IF NOT EXISTS [searches] (
   query TEXT NOT NULL PRIMARY KEY,
   time DATETIME NOT NULL,
   results TEXT
 );
+CREATE TABLE IF NOT EXISTS [library] (
+  path TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  album TEXT
+);
+CREATE TABLE IF NOT EXISTS [reviews] (
+  album TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  review TEXT
+);
+CREATE TABLE IF NOT EXISTS [albums] (
+  id TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  album TEXT
+);
+CREATE TABLE IF NOT EXISTS [syncstate] (
+  path TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  state TEXT
+);
+CREATE TABLE IF NOT EXISTS [aliases] (
+  artist TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  alias TEXT
+);
+CREATE TABLE IF NOT EXISTS [artists] (
+  artist TEXT NOT NULL PRIMARY KEY,
+  time DATETIME NOT NULL,
+  pin TEXT
+);
@@ -1,12 +1,17 @@
 package cache
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
 )
 
 const (
@@ -230,3 +235,252 @@ func TestAlbumUpdate(t *testing.T) {
 		t.Errorf("Row count mismatch. Got %d, wanted 1", i)
 	}
 }
+
+func TestLibraryIndex(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	c, err := initCache(fname)
+	if err != nil {
+		t.Fatalf("initCache(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	const path = "/mp3/Artist/Album"
+	if a, err := c.LookupAlbum(path); err == nil {
+		t.Errorf("c.LookupAlbum(\"%s\"): %v, %v", path, a, err)
+	}
+	want := &media.IndexedAlbum{
+		Path: path,
+		Files: []media.IndexedFile{
+			{
+				Name:    "01 Track.mp3",
+				Size:    1234,
+				ModTime: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+			},
+		},
+		Album: &media.AlbumMetadata{
+			Artist: "Artist",
+			Name:   "Album",
+			Tracks: []media.Track{
+				{Number: 1, Disc: 1, Title: "Track", Filename: "01 Track.mp3", Format: "mp3"},
+			},
+		},
+	}
+	if err := c.UpsertAlbum(want); err != nil {
+		t.Errorf("c.UpsertAlbum(...): %v", err)
+	}
+	got, err := c.LookupAlbum(path)
+	if err != nil {
+		t.Errorf("c.LookupAlbum(\"%s\"): %v", path, err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("c.LookupAlbum(\"%s\") -want, +got: %s", path, diff)
+	}
+	paths, err := c.AlbumPaths()
+	if err != nil {
+		t.Errorf("c.AlbumPaths(): %v", err)
+	}
+	if diff := cmp.Diff([]string{path}, paths); diff != "" {
+		t.Errorf("c.AlbumPaths() -want, +got: %s", diff)
+	}
+	if err := c.DeleteAlbum(path); err != nil {
+		t.Errorf("c.DeleteAlbum(\"%s\"): %v", path, err)
+	}
+	if a, err := c.LookupAlbum(path); err == nil {
+		t.Errorf("c.LookupAlbum(\"%s\") after delete: %v, %v", path, a, err)
+	}
+}
+
+func TestReviews(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	c, err := initCache(fname)
+	if err != nil {
+		t.Fatalf("initCache(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	want := []*Review{
+		{
+			Album: &media.AlbumMetadata{Artist: "Artist", Name: "Album"},
+			Candidates: []spotify.SimpleAlbum{
+				{
+					Name: "Album (Deluxe)",
+					ID:   "album1",
+				},
+			},
+		},
+	}
+	if err := c.UpsertReview(want[0]); err != nil {
+		t.Errorf("c.UpsertReview(...): %v", err)
+	}
+	// Upserting again replaces rather than duplicates.
+	if err := c.UpsertReview(want[0]); err != nil {
+		t.Errorf("c.UpsertReview(...): %v", err)
+	}
+	got, err := c.Reviews()
+	if err != nil {
+		t.Errorf("c.Reviews(): %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("c.Reviews() -want, +got: %s", diff)
+	}
+	if err := c.DeleteReview(want[0]); err != nil {
+		t.Errorf("c.DeleteReview(...): %v", err)
+	}
+	got, err = c.Reviews()
+	if err != nil || len(got) != 0 {
+		t.Errorf("c.Reviews() after delete: %v, %v", got, err)
+	}
+}
+
+func TestSyncStates(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	c, err := initCache(fname)
+	if err != nil {
+		t.Fatalf("initCache(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	seen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := &SyncState{
+		Path:        "music/Artist/Album",
+		Artist:      "Artist",
+		Name:        "Album",
+		SpotifyID:   "album1",
+		LocalSeen:   seen,
+		SpotifySeen: seen,
+	}
+	if _, err := c.SyncState(want.Path); err == nil {
+		t.Errorf("c.SyncState(%q) before upsert: got nil err", want.Path)
+	}
+	if err := c.UpsertSyncState(want); err != nil {
+		t.Errorf("c.UpsertSyncState(...): %v", err)
+	}
+	want.SpotifyGone = seen.Add(time.Hour)
+	if err := c.UpsertSyncState(want); err != nil {
+		t.Errorf("c.UpsertSyncState(...): %v", err)
+	}
+	got, err := c.SyncStates()
+	if err != nil {
+		t.Errorf("c.SyncStates(): %v", err)
+	}
+	if diff := cmp.Diff([]*SyncState{want}, got); diff != "" {
+		t.Errorf("c.SyncStates() -want, +got: %s", diff)
+	}
+	if err := c.DeleteSyncState(want.Path); err != nil {
+		t.Errorf("c.DeleteSyncState(...): %v", err)
+	}
+	if got, err := c.SyncStates(); err != nil || len(got) != 0 {
+		t.Errorf("c.SyncStates() after delete: %v, %v", got, err)
+	}
+
+	// Albums without paths are kept apart by name.
+	for _, name := range []string{"Album1", "Album2"} {
+		if err := c.UpsertSyncState(&SyncState{Artist: "Artist", Name: name, SpotifyID: spotify.ID(name)}); err != nil {
+			t.Errorf("c.UpsertSyncState(...): %v", err)
+		}
+	}
+	st, err := c.SyncState(SyncKey("", "artist", "ALBUM1"))
+	if err != nil || st.SpotifyID != "Album1" {
+		t.Errorf("c.SyncState(Artist / Album1): got %v, %v", st, err)
+	}
+	if got, err := c.SyncStates(); err != nil || len(got) != 2 {
+		t.Errorf("c.SyncStates() without paths: got %v, %v, want 2", got, err)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	c, err := initCache(fname)
+	if err != nil {
+		t.Fatalf("initCache(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	a := &Alias{Artist: "Prince", Names: []string{"The Artist Formerly Known As Prince"}}
+	if err := c.UpsertAlias(a); err != nil {
+		t.Errorf("c.UpsertAlias(...): %v", err)
+	}
+	// The same artist, written differently, replaces it.
+	want := &Alias{Artist: "PRINCE", IDs: []spotify.ID{"5a2EaR3hamoenG9rDuVn8j"}}
+	if err := c.UpsertAlias(want); err != nil {
+		t.Errorf("c.UpsertAlias(...): %v", err)
+	}
+	got, err := c.Aliases()
+	if err != nil {
+		t.Errorf("c.Aliases(): %v", err)
+	}
+	if diff := cmp.Diff([]*Alias{want}, got); diff != "" {
+		t.Errorf("c.Aliases() -want, +got: %s", diff)
+	}
+	if err := c.DeleteAlias(a); err != nil {
+		t.Errorf("c.DeleteAlias(...): %v", err)
+	}
+	if got, err := c.Aliases(); err != nil || len(got) != 0 {
+		t.Errorf("c.Aliases() after delete: %v, %v", got, err)
+	}
+}
+
+func TestArtistPins(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	c, err := initCache(fname)
+	if err != nil {
+		t.Fatalf("initCache(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	want := &ArtistPin{
+		Artist:  "Artist",
+		ID:      "artist1",
+		Name:    "Artist",
+		Albums:  []spotify.SimpleAlbum{{ID: "album1", Name: "Album"}},
+		Fetched: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if _, err := c.ArtistPin(want.Artist); err == nil {
+		t.Errorf("c.ArtistPin(%q) before upsert: got nil err", want.Artist)
+	}
+	if err := c.UpsertArtistPin(want); err != nil {
+		t.Errorf("c.UpsertArtistPin(...): %v", err)
+	}
+	got, err := c.ArtistPin("ARTIST")
+	if err != nil {
+		t.Errorf("c.ArtistPin(\"ARTIST\"): %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("c.ArtistPin(\"ARTIST\") -want, +got: %s", diff)
+	}
+	all, err := c.ArtistPins()
+	if err != nil {
+		t.Errorf("c.ArtistPins(): %v", err)
+	}
+	if diff := cmp.Diff([]*ArtistPin{want}, all); diff != "" {
+		t.Errorf("c.ArtistPins() -want, +got: %s", diff)
+	}
+	if err := c.DeleteArtistPin(&ArtistPin{Artist: "artist"}); err != nil {
+		t.Errorf("c.DeleteArtistPin(...): %v", err)
+	}
+	if _, err := c.ArtistPin(want.Artist); err == nil {
+		t.Errorf("c.ArtistPin(%q) after delete: got nil err", want.Artist)
+	}
+}
+
+func TestNewAddsTables(t *testing.T) {
+	fname := fmt.Sprintf("%s/%s", t.TempDir(), testDBFile)
+	db, err := sql.Open("sqlite3", fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A cache from before there were any tables but searches.
+	if _, err := db.Exec("CREATE TABLE [searches] (query TEXT NOT NULL PRIMARY KEY, time DATETIME NOT NULL, results TEXT);"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	c, err := New(fname, Options{})
+	if err != nil {
+		t.Fatalf("New(\"%s\"): %v", fname, err)
+	}
+	defer c.Close()
+	want := make([]string, 0)
+	for _, m := range regexp.MustCompile(`CREATE TABLE IF NOT EXISTS \[(\w+)\]`).FindAllStringSubmatch(schema, -1) {
+		want = append(want, m[1])
+	}
+	for _, table := range want {
+		if _, err := c.keysAny(table, "time"); err != nil {
+			t.Errorf("table %s after New: %v", table, err)
+		}
+	}
+}
@@ -3,9 +3,11 @@ module github.com/tschroed/spotsync/v2
 go 1.22.5
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/google/go-cmp v0.6.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/zmb3/spotify/v2 v2.4.2
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -16,6 +18,7 @@ require (
 	github.com/zmb3/spotify v1.3.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/oauth2 v0.0.0-20210810183815-faf39c7919d5 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 )
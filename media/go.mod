@@ -2,4 +2,9 @@ module github.com/spotsync/media/v2
 
 go 1.22.5
 
-require github.com/google/go-cmp v0.6.0
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/google/go-cmp v0.6.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
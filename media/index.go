@@ -0,0 +1,125 @@
+package media
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// IndexedFile is the scanner's record of a single audio file, used to
+// tell whether an album has changed since it was last scanned.
+type IndexedFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// IndexedAlbum is the scanner's record of an album directory.
+type IndexedAlbum struct {
+	Path  string
+	Files []IndexedFile
+	Album *AlbumMetadata
+}
+
+// Index persists IndexedAlbums between runs so that only new or changed
+// albums need to be yielded. Lookups of unknown paths return an error.
+type Index interface {
+	LookupAlbum(path string) (*IndexedAlbum, error)
+	UpsertAlbum(a *IndexedAlbum) error
+	DeleteAlbum(path string) error
+	AlbumPaths() ([]string, error)
+}
+
+// unchanged reports whether the album at path is in the index with
+// exactly the given files.
+func (d *directoryAlbumProducer) unchanged(path string, files []IndexedFile) bool {
+	d.idxMu.Lock()
+	defer d.idxMu.Unlock()
+	d.seen[path] = true
+	if d.full {
+		return false
+	}
+	old, err := d.index.LookupAlbum(path)
+	if err != nil {
+		return false
+	}
+	return slices.EqualFunc(old.Files, files, func(a, b IndexedFile) bool {
+		return a.Name == b.Name && a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+	})
+}
+
+// hold keeps a until the album it records has been synced, so that an
+// album yielded but never synced is yielded again by the next scan.
+func (d *directoryAlbumProducer) hold(a *IndexedAlbum) {
+	d.idxMu.Lock()
+	defer d.idxMu.Unlock()
+	d.pending[a.Path] = a
+}
+
+// Synced records alb, yielded by Albums(), in the index now that the
+// consumer has synced it, so that later scans skip it while unchanged.
+func (d *directoryAlbumProducer) Synced(alb *AlbumMetadata) {
+	d.idxMu.Lock()
+	defer d.idxMu.Unlock()
+	a := d.pending[alb.Path]
+	if a == nil {
+		return
+	}
+	delete(d.pending, alb.Path)
+	if err := d.index.UpsertAlbum(a); err != nil {
+		d.fail(err)
+	}
+}
+
+// Disappeared returns the indexed albums beneath the scanned roots which
+// were not found by this scan, and forgets them. It should be called once
+// Albums() has been exhausted, and returns nothing if the scan was cut
+// short or there is no index.
+func (d *directoryAlbumProducer) Disappeared() []*IndexedAlbum {
+	if d.index == nil {
+		return nil
+	}
+	d.mu.Lock()
+	failed := slices.Clone(d.failed)
+	interrupted := d.interrupted
+	d.mu.Unlock()
+	if interrupted {
+		return nil
+	}
+	d.idxMu.Lock()
+	defer d.idxMu.Unlock()
+	paths, err := d.index.AlbumPaths()
+	if err != nil {
+		d.fail(err)
+		return nil
+	}
+	gone := make([]*IndexedAlbum, 0)
+	for _, p := range paths {
+		if d.seen[p] || !underAny(p, d.roots) || underAny(p, failed) {
+			continue
+		}
+		a, err := d.index.LookupAlbum(p)
+		if err != nil {
+			d.fail(err)
+			continue
+		}
+		if err := d.index.DeleteAlbum(p); err != nil {
+			d.fail(err)
+			continue
+		}
+		gone = append(gone, a)
+	}
+	return gone
+}
+
+// underAny reports whether p is one of dirs or beneath one of them.
+func underAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		if dir == "." || p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
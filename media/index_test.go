@@ -0,0 +1,173 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type mapIndex map[string]*IndexedAlbum
+
+func (m mapIndex) LookupAlbum(path string) (*IndexedAlbum, error) {
+	a, ok := m[path]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return a, nil
+}
+
+func (m mapIndex) UpsertAlbum(a *IndexedAlbum) error {
+	m[a.Path] = a
+	return nil
+}
+
+func (m mapIndex) DeleteAlbum(path string) error {
+	delete(m, path)
+	return nil
+}
+
+func (m mapIndex) AlbumPaths() ([]string, error) {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+func scan(t *testing.T, root string, o Options) ([]string, []string) {
+	t.Helper()
+	d, err := NewDirectoryAlbumProducer(os.DirFS(root), []string{"."}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		d.Start(context.Background())
+	}()
+	found := make([]string, 0)
+	for a := range d.Albums() {
+		found = append(found, a.Name)
+		d.Synced(a)
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("d.Err(): %v", err)
+	}
+	gone := make([]string, 0)
+	for _, a := range d.Disappeared() {
+		gone = append(gone, a.Album.Name)
+	}
+	sort.Strings(found)
+	sort.Strings(gone)
+	return found, gone
+}
+
+func TestIncrementalScan(t *testing.T) {
+	tmp := t.TempDir()
+	for _, p := range []string{"Artist1/Title1/Track1.mp3", "Artist1/Title2/Track2.mp3", "Artist2/Title3/CD1/Track3.mp3"} {
+		p = filepath.Join(tmp, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := make(mapIndex)
+	steps := []struct {
+		name      string
+		change    func() error
+		full      bool
+		wantFound []string
+		wantGone  []string
+	}{
+		{
+			name:      "first scan",
+			change:    func() error { return nil },
+			wantFound: []string{"Title1", "Title2", "Title3"},
+		},
+		{
+			name:   "unchanged",
+			change: func() error { return nil },
+		},
+		{
+			name: "track added to disc",
+			change: func() error {
+				_, err := os.Create(filepath.Join(tmp, "Artist2/Title3/CD1/Track4.mp3"))
+				return err
+			},
+			wantFound: []string{"Title3"},
+		},
+		{
+			name: "track rewritten",
+			change: func() error {
+				return os.WriteFile(filepath.Join(tmp, "Artist1/Title1/Track1.mp3"), []byte("ID3"), 0640)
+			},
+			wantFound: []string{"Title1"},
+		},
+		{
+			name: "album removed",
+			change: func() error {
+				return os.RemoveAll(filepath.Join(tmp, "Artist1/Title2"))
+			},
+			wantGone: []string{"Title2"},
+		},
+		{
+			name:      "full rescan",
+			change:    func() error { return nil },
+			full:      true,
+			wantFound: []string{"Title1", "Title3"},
+		},
+	}
+	for _, s := range steps {
+		if err := s.change(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		found, gone := scan(t, tmp, Options{Index: idx, Full: s.full})
+		if diff := cmp.Diff(s.wantFound, found, cmpEmpty()); diff != "" {
+			t.Errorf("%s: found mismatch (-want +got):\n%s", s.name, diff)
+		}
+		if diff := cmp.Diff(s.wantGone, gone, cmpEmpty()); diff != "" {
+			t.Errorf("%s: disappeared mismatch (-want +got):\n%s", s.name, diff)
+		}
+	}
+}
+
+// cmpEmpty treats nil and empty slices alike.
+func cmpEmpty() cmp.Option {
+	return cmp.FilterValues(func(x, y []string) bool {
+		return len(x) == 0 && len(y) == 0
+	}, cmp.Ignore())
+}
+
+func TestUnsyncedRescanned(t *testing.T) {
+	tmp := t.TempDir()
+	p := filepath.Join(tmp, "Artist1/Title1/Track1.mp3")
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	idx := make(mapIndex)
+	d, err := NewDirectoryAlbumProducer(os.DirFS(tmp), []string{"."}, Options{Index: idx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		d.Start(context.Background())
+	}()
+	// As if the sync stopped before getting to the album.
+	for range d.Albums() {
+	}
+	if len(idx) != 0 {
+		t.Errorf("index after unsynced scan: got %v, want nothing", idx)
+	}
+	found, _ := scan(t, tmp, Options{Index: idx})
+	if diff := cmp.Diff([]string{"Title1"}, found); diff != "" {
+		t.Errorf("rescan: found mismatch (-want +got):\n%s", diff)
+	}
+}
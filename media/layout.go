@@ -0,0 +1,126 @@
+package media
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	fieldGenre  = "{genre}"
+	fieldArtist = "{artist}"
+	fieldAlbum  = "{album}"
+	fieldDisc   = "{disc}"
+
+	// VariousArtists is the artist given to albums found under a layout
+	// which has no {artist} component, e.g. "Compilations/{album}".
+	VariousArtists = "Various Artists"
+)
+
+var (
+	// DefaultLayouts is the classic root/Artist/Album arrangement.
+	DefaultLayouts = []string{"{artist}/{album}"}
+
+	discMatcher = regexp.MustCompile("^(?i)(?:cd|dis[ck])[ _.-]*(?P<num>[0-9]+)$")
+)
+
+// Layout describes how album directories are arranged beneath a library
+// root, e.g. "{genre}/{artist}/{album}/{disc}". Each component of the
+// template is either a placeholder or a literal directory name, which is
+// matched case-insensitively. A {disc} component is optional and may only
+// appear last; disc directories are detected and merged regardless.
+type Layout struct {
+	template   string
+	components []string
+}
+
+// ParseLayout parses a layout template.
+func ParseLayout(template string) (*Layout, error) {
+	comps := strings.Split(strings.Trim(template, "/"), "/")
+	seen := make(map[string]bool)
+	l := &Layout{template: template}
+	for i, c := range comps {
+		if c == "" {
+			return nil, fmt.Errorf("layout %q: empty component", template)
+		}
+		if strings.HasPrefix(c, "{") {
+			switch c {
+			case fieldGenre, fieldArtist, fieldAlbum:
+			case fieldDisc:
+				if i != len(comps)-1 {
+					return nil, fmt.Errorf("layout %q: %s must be the last component", template, fieldDisc)
+				}
+				// Discs are handled when walking, so there's
+				// nothing to match here.
+				continue
+			default:
+				return nil, fmt.Errorf("layout %q: unknown placeholder %s", template, c)
+			}
+			if seen[c] {
+				return nil, fmt.Errorf("layout %q: duplicate placeholder %s", template, c)
+			}
+			seen[c] = true
+		}
+		l.components = append(l.components, c)
+	}
+	if !seen[fieldAlbum] {
+		return nil, fmt.Errorf("layout %q: missing %s", template, fieldAlbum)
+	}
+	return l, nil
+}
+
+// ParseLayouts parses a list of layout templates, preserving order.
+func ParseLayouts(templates []string) ([]*Layout, error) {
+	ls := make([]*Layout, 0, len(templates))
+	for _, t := range templates {
+		l, err := ParseLayout(t)
+		if err != nil {
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+func (l *Layout) String() string {
+	return l.template
+}
+
+// match maps the path components of an album directory, relative to the
+// library root and excluding any disc directory, onto the layout.
+func (l *Layout) match(path []string) (*AlbumMetadata, bool) {
+	if len(path) != len(l.components) {
+		return nil, false
+	}
+	a := &AlbumMetadata{Artist: VariousArtists}
+	for i, c := range l.components {
+		switch c {
+		case fieldGenre:
+			a.Genre = path[i]
+		case fieldArtist:
+			a.Artist = path[i]
+		case fieldAlbum:
+			a.Name = path[i]
+		default:
+			if !strings.EqualFold(c, path[i]) {
+				return nil, false
+			}
+		}
+	}
+	return a, true
+}
+
+// discNumber reports whether name looks like a disc directory of a
+// multi-disc album ("CD1", "Disc 2", ...) and if so, its number.
+func discNumber(name string) (int, bool) {
+	m := discMatcher.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[discMatcher.SubexpIndex("num")])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,503 @@
+package media
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Library is a music library as exported by a player: the Library.xml of
+// iTunes or Music, or a generic CSV or JSON listing of tracks. Unlike a
+// directory, it knows tracks' ratings and play counts, and has playlists.
+type Library struct {
+	albums    []*AlbumMetadata
+	byKey     map[string]*AlbumMetadata
+	byPath    map[string]libraryTrack
+	playlists []*Playlist
+	ch        chan *AlbumMetadata
+}
+
+type libraryTrack struct {
+	album *AlbumMetadata
+	track Track
+}
+
+func newLibrary() *Library {
+	return &Library{
+		byKey:  make(map[string]*AlbumMetadata),
+		byPath: make(map[string]libraryTrack),
+		ch:     make(chan *AlbumMetadata, 20),
+	}
+}
+
+// add files t under its album, creating that if need be. p is the track's
+// file within os.DirFS("/"), or "" if it has none.
+func (l *Library) add(albumArtist string, album string, genre string, year int, t Track, p string) {
+	k := albumArtist + "\x00" + album
+	a := l.byKey[k]
+	if a == nil {
+		a = &AlbumMetadata{Artist: albumArtist, Name: album, Genre: genre, Year: year}
+		l.byKey[k] = a
+		l.albums = append(l.albums, a)
+	}
+	if a.Year == 0 {
+		a.Year = parseYear(album)
+	}
+	if t.Artist == albumArtist {
+		t.Artist = ""
+	}
+	if t.Disc == 0 {
+		t.Disc = 1
+	}
+	if p != "" {
+		// An album's tracks may be spread over directories, CD1/ and
+		// CD2/ say, so its path is their common parent, and filenames
+		// are made relative to that by finish.
+		if a.Path == "" {
+			a.Path = path.Dir(p)
+		} else {
+			a.Path = commonDir(a.Path, path.Dir(p))
+		}
+		t.Filename = p
+		t.Format = strings.TrimPrefix(strings.ToLower(path.Ext(p)), ".")
+	}
+	a.Tracks = append(a.Tracks, t)
+	a.Duration += t.Duration
+	if p != "" {
+		l.byPath[p] = libraryTrack{album: a, track: t}
+	}
+}
+
+// finish makes tracks' filenames relative to their album's path, and puts
+// each album's tracks in order.
+func (l *Library) finish() {
+	for p, lt := range l.byPath {
+		l.byPath[p] = lt.relative()
+	}
+	for _, a := range l.albums {
+		for i := range a.Tracks {
+			a.Tracks[i] = libraryTrack{album: a, track: a.Tracks[i]}.relative().track
+		}
+		sort.SliceStable(a.Tracks, func(i, j int) bool {
+			if a.Tracks[i].Disc != a.Tracks[j].Disc {
+				return a.Tracks[i].Disc < a.Tracks[j].Disc
+			}
+			return a.Tracks[i].Number < a.Tracks[j].Number
+		})
+	}
+}
+
+// relative returns lt with its track's filename, which add leaves as the
+// path within os.DirFS("/"), made relative to its album's path.
+func (lt libraryTrack) relative() libraryTrack {
+	if lt.track.Filename != "" && lt.album.Path != "." {
+		lt.track.Filename = strings.TrimPrefix(lt.track.Filename, lt.album.Path+"/")
+	}
+	return lt
+}
+
+// commonDir returns the deepest directory holding both dir and other, or
+// "." if there's none.
+func commonDir(dir string, other string) string {
+	for dir != "." && other != dir && !strings.HasPrefix(other, dir+"/") {
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+func (l *Library) Albums() AlbumIterFn {
+	return AlbumIterator(l.ch)
+}
+
+// AlbumList returns all of the library's albums, in the order first seen,
+// without the need to Start it.
+func (l *Library) AlbumList() []*AlbumMetadata {
+	return l.albums
+}
+
+// Start yields the library's albums, in the order first seen, closing the
+// channel once done or ctx is.
+func (l *Library) Start(ctx context.Context) {
+	defer close(l.ch)
+	for _, a := range l.albums {
+		select {
+		case l.ch <- a:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Err always returns nil, since a Library is read up front.
+func (l *Library) Err() error {
+	return nil
+}
+
+// Disappeared always returns nothing, since a Library has no Index.
+func (l *Library) Disappeared() []*IndexedAlbum {
+	return nil
+}
+
+// Playlists returns the library's own playlists, if it has any.
+func (l *Library) Playlists() []*Playlist {
+	return l.playlists
+}
+
+// RatingPlaylists returns a playlist per star rating, "1 star" to
+// "5 stars", of the tracks rated that, leaving out ratings nothing has.
+func (l *Library) RatingPlaylists() []*Playlist {
+	byStars := make([]*Playlist, 6)
+	for _, a := range l.albums {
+		for _, t := range a.Tracks {
+			stars := (t.Rating + 10) / 20
+			if stars < 1 || stars > 5 {
+				continue
+			}
+			if byStars[stars] == nil {
+				name := fmt.Sprintf("%d stars", stars)
+				if stars == 1 {
+					name = "1 star"
+				}
+				byStars[stars] = &Playlist{Name: name}
+			}
+			byStars[stars].Entries = append(byStars[stars].Entries, l.entry(a, t))
+		}
+	}
+	pls := make([]*Playlist, 0)
+	for stars := 5; stars >= 1; stars-- {
+		if byStars[stars] != nil {
+			pls = append(pls, byStars[stars])
+		}
+	}
+	return pls
+}
+
+// entry makes a PlaylistEntry of a track of the library.
+func (l *Library) entry(a *AlbumMetadata, t Track) PlaylistEntry {
+	e := PlaylistEntry{Duration: t.Duration}
+	artist := a.Artist
+	if t.Artist != "" {
+		artist = t.Artist
+	}
+	e.Title = artist + " - " + t.Title
+	if a.Path != "" && t.Filename != "" {
+		e.Path = path.Join(a.Path, t.Filename)
+		e.Location = "/" + e.Path
+	}
+	return e
+}
+
+// Resolve returns the album holding the track at p, a path within
+// os.DirFS("/"), with just that track.
+func (l *Library) Resolve(p string) (*AlbumMetadata, error) {
+	lt, ok := l.byPath[p]
+	if !ok {
+		return nil, fmt.Errorf("%s: not in library", p)
+	}
+	a := *lt.album
+	a.Tracks = []Track{lt.track}
+	a.Duration = lt.track.Duration
+	return &a, nil
+}
+
+// ReadITunesLibrary reads the Library.xml exported by iTunes or Music.
+// Videos and podcasts are left out, as are the built-in playlists.
+func ReadITunesLibrary(r io.Reader) (*Library, error) {
+	v, err := decodePlist(xml.NewDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("reading iTunes library: %w", err)
+	}
+	root, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("reading iTunes library: not a dictionary")
+	}
+	tracks, _ := root["Tracks"].(map[string]any)
+	// Tracks are keyed by ID, so go in ID order for a stable album order.
+	ids := make([]string, 0, len(tracks))
+	for id := range tracks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	l := newLibrary()
+	byID := make(map[int64]libraryTrack)
+	for _, id := range ids {
+		it, ok := tracks[id].(map[string]any)
+		if !ok || plistBool(it, "Podcast") || plistBool(it, "Movie") || plistBool(it, "TV Show") || plistBool(it, "Music Video") {
+			continue
+		}
+		t := Track{
+			Number:   int(plistInt(it, "Track Number")),
+			Disc:     int(plistInt(it, "Disc Number")),
+			Title:    plistString(it, "Name"),
+			Artist:   plistString(it, "Artist"),
+			Duration: time.Duration(plistInt(it, "Total Time")) * time.Millisecond,
+			Bitrate:  int(plistInt(it, "Bit Rate")),
+			Plays:    int(plistInt(it, "Play Count")),
+		}
+		// Computed ratings are just the album's rating.
+		if !plistBool(it, "Rating Computed") {
+			t.Rating = int(plistInt(it, "Rating"))
+		}
+		t.Added, _ = it["Date Added"].(time.Time)
+		albumArtist := plistString(it, "Album Artist")
+		switch {
+		case albumArtist != "":
+		case plistBool(it, "Compilation"):
+			albumArtist = VariousArtists
+		default:
+			albumArtist = t.Artist
+		}
+		p, _ := fileURLPath(plistString(it, "Location"))
+		l.add(albumArtist, plistString(it, "Album"), plistString(it, "Genre"), int(plistInt(it, "Year")), t, p)
+		a := l.byKey[albumArtist+"\x00"+plistString(it, "Album")]
+		byID[plistInt(it, "Track ID")] = libraryTrack{album: a, track: a.Tracks[len(a.Tracks)-1]}
+	}
+	l.finish()
+	for id, lt := range byID {
+		byID[id] = lt.relative()
+	}
+	playlists, _ := root["Playlists"].([]any)
+	for _, v := range playlists {
+		ip, ok := v.(map[string]any)
+		if !ok || plistBool(ip, "Master") || plistBool(ip, "Folder") || ip["Distinguished Kind"] != nil {
+			continue
+		}
+		pl := &Playlist{Name: plistString(ip, "Name")}
+		items, _ := ip["Playlist Items"].([]any)
+		for _, v := range items {
+			item, _ := v.(map[string]any)
+			if lt, ok := byID[plistInt(item, "Track ID")]; ok {
+				pl.Entries = append(pl.Entries, l.entry(lt.album, lt.track))
+			}
+		}
+		l.playlists = append(l.playlists, pl)
+	}
+	return l, nil
+}
+
+func plistString(m map[string]any, k string) string {
+	s, _ := m[k].(string)
+	return s
+}
+
+func plistInt(m map[string]any, k string) int64 {
+	n, _ := m[k].(int64)
+	return n
+}
+
+func plistBool(m map[string]any, k string) bool {
+	b, _ := m[k].(bool)
+	return b
+}
+
+// decodePlist decodes the next value of an XML property list: a
+// map[string]any, []any, string, int64, float64, bool or time.Time.
+func decodePlist(d *xml.Decoder) (any, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			return nil, errPlistEnd
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "plist":
+			continue
+		case "dict":
+			m := make(map[string]any)
+			for {
+				k, err := plistKey(d)
+				if err != nil {
+					return nil, err
+				}
+				if k == nil {
+					return m, nil
+				}
+				if m[*k], err = decodePlist(d); err != nil {
+					return nil, err
+				}
+			}
+		case "array":
+			a := make([]any, 0)
+			for {
+				v, err := decodePlist(d)
+				if errors.Is(err, errPlistEnd) {
+					return a, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, v)
+			}
+		case "true", "false":
+			if err := d.Skip(); err != nil {
+				return nil, err
+			}
+			return start.Name.Local == "true", nil
+		}
+		var text string
+		if err := d.DecodeElement(&text, &start); err != nil {
+			return nil, err
+		}
+		switch start.Name.Local {
+		case "integer":
+			return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		case "real":
+			return strconv.ParseFloat(strings.TrimSpace(text), 64)
+		case "date":
+			return time.Parse(time.RFC3339, strings.TrimSpace(text))
+		default:
+			// string, data and anything newer.
+			return text, nil
+		}
+	}
+}
+
+// errPlistEnd marks the end of an array.
+var errPlistEnd = errors.New("end of plist array")
+
+// plistKey reads the next key of a dict, or returns nil at its end.
+func plistKey(d *xml.Decoder) (*string, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var k string
+			if err := d.DecodeElement(&k, &t); err != nil {
+				return nil, err
+			}
+			return &k, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+// ReadListing reads a generic listing of tracks, format being "csv" or
+// "json". CSV listings need a header row; JSON ones are an array of
+// objects. Either way the fields, all optional but title, are: artist,
+// album artist, album, title, track, disc, genre, year, duration (seconds
+// or m:ss), rating (stars out of 5, or out of 100), plays, added
+// (YYYY-MM-DD or RFC 3339) and path (a local file).
+func ReadListing(r io.Reader, format string) (*Library, error) {
+	var rows []map[string]string
+	switch format {
+	case "csv":
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("reading listing: no header")
+		}
+		header := records[0]
+		for _, rec := range records[1:] {
+			row := make(map[string]string)
+			for i, v := range rec {
+				if i < len(header) {
+					row[strings.ToLower(strings.TrimSpace(header[i]))] = strings.TrimSpace(v)
+				}
+			}
+			rows = append(rows, row)
+		}
+	case "json":
+		var objs []map[string]any
+		if err := json.NewDecoder(r).Decode(&objs); err != nil {
+			return nil, fmt.Errorf("reading listing: %w", err)
+		}
+		for _, o := range objs {
+			row := make(map[string]string)
+			for k, v := range o {
+				if v != nil {
+					row[strings.ToLower(k)] = fmt.Sprint(v)
+				}
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, fmt.Errorf("unknown listing format %q", format)
+	}
+	l := newLibrary()
+	for i, row := range rows {
+		t, err := listingTrack(row)
+		if err != nil {
+			return nil, fmt.Errorf("reading listing: entry %d: %w", i+1, err)
+		}
+		albumArtist := row["album artist"]
+		if albumArtist == "" {
+			albumArtist = t.Artist
+		}
+		var p string
+		if row["path"] != "" {
+			if p, err = LocalRoot(row["path"]); err != nil {
+				return nil, err
+			}
+		}
+		year, _ := strconv.Atoi(row["year"])
+		l.add(albumArtist, row["album"], row["genre"], year, t, p)
+	}
+	l.finish()
+	return l, nil
+}
+
+func listingTrack(row map[string]string) (Track, error) {
+	t := Track{Title: row["title"], Artist: row["artist"]}
+	if t.Title == "" {
+		return t, errors.New("no title")
+	}
+	t.Number, _ = strconv.Atoi(row["track"])
+	t.Disc, _ = strconv.Atoi(row["disc"])
+	t.Plays, _ = strconv.Atoi(row["plays"])
+	if d := row["duration"]; d != "" {
+		m, s, ok := strings.Cut(d, ":")
+		if !ok {
+			m, s = "0", m
+		}
+		mins, err1 := strconv.Atoi(m)
+		secs, err2 := strconv.ParseFloat(s, 64)
+		if err := errors.Join(err1, err2); err != nil {
+			return t, fmt.Errorf("duration %q: %w", d, err)
+		}
+		t.Duration = time.Duration(mins)*time.Minute + time.Duration(secs*float64(time.Second))
+	}
+	if r := row["rating"]; r != "" {
+		n, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return t, fmt.Errorf("rating %q: %w", r, err)
+		}
+		if n <= 5 {
+			n *= 20
+		}
+		t.Rating = int(n)
+	}
+	if a := row["added"]; a != "" {
+		var err error
+		if t.Added, err = time.Parse(time.DateOnly, a); err != nil {
+			if t.Added, err = time.Parse(time.RFC3339, a); err != nil {
+				return t, fmt.Errorf("added %q: %w", a, err)
+			}
+		}
+	}
+	return t, nil
+}
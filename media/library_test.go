@@ -0,0 +1,236 @@
+package media
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testITunesLibrary = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Major Version</key><integer>1</integer>
+	<key>Tracks</key>
+	<dict>
+		<key>102</key>
+		<dict>
+			<key>Track ID</key><integer>102</integer>
+			<key>Name</key><string>Two</string>
+			<key>Artist</key><string>Artist</string>
+			<key>Album</key><string>Title</string>
+			<key>Genre</key><string>Rock</string>
+			<key>Total Time</key><integer>180000</integer>
+			<key>Track Number</key><integer>2</integer>
+			<key>Year</key><integer>1999</integer>
+			<key>Play Count</key><integer>3</integer>
+			<key>Rating</key><integer>60</integer>
+			<key>Rating Computed</key><true/>
+			<key>Location</key><string>file:///music/Artist/Title/02%20Two.mp3</string>
+		</dict>
+		<key>101</key>
+		<dict>
+			<key>Track ID</key><integer>101</integer>
+			<key>Name</key><string>One</string>
+			<key>Artist</key><string>Artist</string>
+			<key>Album</key><string>Title</string>
+			<key>Genre</key><string>Rock</string>
+			<key>Total Time</key><integer>200000</integer>
+			<key>Track Number</key><integer>1</integer>
+			<key>Year</key><integer>1999</integer>
+			<key>Date Added</key><date>2010-05-01T12:00:00Z</date>
+			<key>Play Count</key><integer>42</integer>
+			<key>Rating</key><integer>100</integer>
+			<key>Location</key><string>file:///music/Artist/Title/01%20One.mp3</string>
+		</dict>
+		<key>103</key>
+		<dict>
+			<key>Track ID</key><integer>103</integer>
+			<key>Name</key><string>Three</string>
+			<key>Artist</key><string>Other</string>
+			<key>Album</key><string>Mix</string>
+			<key>Compilation</key><true/>
+			<key>Rating</key><integer>80</integer>
+		</dict>
+		<key>104</key>
+		<dict>
+			<key>Track ID</key><integer>104</integer>
+			<key>Name</key><string>Episode</string>
+			<key>Podcast</key><true/>
+		</dict>
+	</dict>
+	<key>Playlists</key>
+	<array>
+		<dict>
+			<key>Name</key><string>Library</string>
+			<key>Master</key><true/>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>101</integer></dict>
+			</array>
+		</dict>
+		<dict>
+			<key>Name</key><string>Favourites</string>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>103</integer></dict>
+				<dict><key>Track ID</key><integer>101</integer></dict>
+				<dict><key>Track ID</key><integer>104</integer></dict>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>
+`
+
+func libraryAlbums(t *testing.T, l *Library) []AlbumMetadata {
+	t.Helper()
+	go l.Start(context.Background())
+	got := make([]AlbumMetadata, 0)
+	for a := range l.Albums() {
+		got = append(got, *a)
+	}
+	return got
+}
+
+func TestReadITunesLibrary(t *testing.T) {
+	l, err := ReadITunesLibrary(strings.NewReader(testITunesLibrary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AlbumMetadata{
+		{
+			Artist: "Artist",
+			Name:   "Title",
+			Genre:  "Rock",
+			Year:   1999,
+			Path:   "music/Artist/Title",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "One", Filename: "01 One.mp3", Format: "mp3", Duration: 200 * time.Second, Rating: 100, Plays: 42, Added: time.Date(2010, 5, 1, 12, 0, 0, 0, time.UTC)},
+				{Number: 2, Disc: 1, Title: "Two", Filename: "02 Two.mp3", Format: "mp3", Duration: 180 * time.Second, Plays: 3},
+			},
+			Duration: 380 * time.Second,
+		},
+		{
+			Artist: VariousArtists,
+			Name:   "Mix",
+			Tracks: []Track{{Disc: 1, Title: "Three", Artist: "Other", Rating: 80}},
+		},
+	}
+	if diff := cmp.Diff(want, libraryAlbums(t, l)); diff != "" {
+		t.Errorf("albums mismatch (-want +got):\n%s", diff)
+	}
+
+	wantPlaylists := []*Playlist{
+		{
+			Name: "Favourites",
+			Entries: []PlaylistEntry{
+				{Title: "Other - Three"},
+				{Location: "/music/Artist/Title/01 One.mp3", Title: "Artist - One", Duration: 200 * time.Second, Path: "music/Artist/Title/01 One.mp3"},
+			},
+		},
+	}
+	if diff := cmp.Diff(wantPlaylists, l.Playlists()); diff != "" {
+		t.Errorf("Playlists mismatch (-want +got):\n%s", diff)
+	}
+	names := make([]string, 0)
+	for _, pl := range l.RatingPlaylists() {
+		names = append(names, pl.Name)
+	}
+	if diff := cmp.Diff([]string{"5 stars", "4 stars"}, names); diff != "" {
+		t.Errorf("RatingPlaylists mismatch (-want +got):\n%s", diff)
+	}
+
+	a, err := l.Resolve("music/Artist/Title/02 Two.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Tracks) != 1 || a.Tracks[0].Title != "Two" || a.Name != "Title" {
+		t.Errorf("Resolve: got %+v", a)
+	}
+	if _, err := l.Resolve("music/Elsewhere.mp3"); err == nil {
+		t.Error("Resolve of unknown path: got nil err")
+	}
+}
+
+func TestReadListing(t *testing.T) {
+	want := []AlbumMetadata{
+		{
+			Artist: "Artist",
+			Name:   "Title",
+			Year:   2001,
+			Path:   "music/Artist/Title",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "One", Filename: "01 One.flac", Format: "flac", Duration: 3*time.Minute + 20*time.Second, Rating: 100, Plays: 7, Added: time.Date(2012, 3, 4, 0, 0, 0, 0, time.UTC)},
+				{Number: 2, Disc: 1, Title: "Two", Artist: "Guest", Filename: "02 Two.flac", Format: "flac", Duration: 90 * time.Second, Rating: 60},
+			},
+			Duration: 3*time.Minute + 20*time.Second + 90*time.Second,
+		},
+	}
+	csv := "Artist,Album Artist,Album,Title,Track,Year,Duration,Rating,Plays,Added,Path\n" +
+		"Guest,Artist,Title,Two,2,2001,90,3,,,/music/Artist/Title/02 Two.flac\n" +
+		"Artist,,Title,One,1,2001,3:20,5,7,2012-03-04,/music/Artist/Title/01 One.flac\n"
+	json := `[
+		{"artist": "Guest", "album artist": "Artist", "album": "Title", "title": "Two", "track": 2, "year": 2001, "duration": 90, "rating": 60, "path": "/music/Artist/Title/02 Two.flac"},
+		{"artist": "Artist", "album": "Title", "title": "One", "track": 1, "year": 2001, "duration": "3:20", "rating": 5, "plays": 7, "added": "2012-03-04T00:00:00Z", "path": "/music/Artist/Title/01 One.flac"}
+	]`
+	for format, listing := range map[string]string{"csv": csv, "json": json} {
+		l, err := ReadListing(strings.NewReader(listing), format)
+		if err != nil {
+			t.Errorf("ReadListing(%s): %v", format, err)
+			continue
+		}
+		if diff := cmp.Diff(want, libraryAlbums(t, l)); diff != "" {
+			t.Errorf("ReadListing(%s) mismatch (-want +got):\n%s", format, diff)
+		}
+	}
+	for _, bad := range []string{"Artist,Title\nArtist,\n", "Title,Duration\nOne,soon\n", "Title,Rating\nOne,high\n"} {
+		if _, err := ReadListing(strings.NewReader(bad), "csv"); err == nil {
+			t.Errorf("ReadListing(%q): got nil err", bad)
+		}
+	}
+	if _, err := ReadListing(strings.NewReader(""), "xml"); err == nil {
+		t.Error("ReadListing of unknown format: got nil err")
+	}
+}
+
+func TestReadListingDiscDirs(t *testing.T) {
+	csv := "Artist,Album,Title,Track,Disc,Rating,Path\n" +
+		"Artist,Title,One,1,1,5,/music/Artist/Title/CD1/01 One.flac\n" +
+		"Artist,Title,Two,1,2,5,/music/Artist/Title/CD2/01 Two.flac\n"
+	l, err := ReadListing(strings.NewReader(csv), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AlbumMetadata{{
+		Artist: "Artist",
+		Name:   "Title",
+		Path:   "music/Artist/Title",
+		Tracks: []Track{
+			{Number: 1, Disc: 1, Title: "One", Filename: "CD1/01 One.flac", Format: "flac", Rating: 100},
+			{Number: 1, Disc: 2, Title: "Two", Filename: "CD2/01 Two.flac", Format: "flac", Rating: 100},
+		},
+	}}
+	if diff := cmp.Diff(want, libraryAlbums(t, l)); diff != "" {
+		t.Errorf("ReadListing mismatch (-want +got):\n%s", diff)
+	}
+	var got []string
+	for _, pl := range l.RatingPlaylists() {
+		for _, e := range pl.Entries {
+			got = append(got, e.Path)
+		}
+	}
+	if diff := cmp.Diff([]string{"music/Artist/Title/CD1/01 One.flac", "music/Artist/Title/CD2/01 Two.flac"}, got); diff != "" {
+		t.Errorf("RatingPlaylists paths mismatch (-want +got):\n%s", diff)
+	}
+	a, err := l.Resolve("music/Artist/Title/CD2/01 Two.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Path + "/" + a.Tracks[0].Filename; got != "music/Artist/Title/CD2/01 Two.flac" {
+		t.Errorf("Resolve: got track at %s", got)
+	}
+}
@@ -1,30 +1,57 @@
 package media
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"iter" // To use the iter package, export GOEXPERIMENT=rangefunc
-	"log"
-	"os"
-	"regexp"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	matcher = regexp.MustCompile("(?:[0-9]{2}\\.? )?(?P<name>.*)\\.(?:mp|MP)3")
+	audioExtensions = []string{".mp3", ".flac", ".ogg", ".oga", ".opus", ".m4a", ".aac", ".wav", ".wma", ".aiff", ".ape", ".mpc"}
 )
 
-func extractTrackName(filename string) string {
-	if !matcher.MatchString(filename) {
-		return filename
-	}
-	matches := matcher.FindStringSubmatch(filename)
-	return matches[matcher.SubexpIndex("name")]
+func isAudioFile(filename string) bool {
+	return slices.Contains(audioExtensions, strings.ToLower(path.Ext(filename)))
 }
 
 // AlbumMetadat contains metadata about an album, artist, name, tracks.
 type AlbumMetadata struct {
 	Artist string
 	Name   string
-	Tracks []string
+	// Genre is only known for layouts with a {genre} component.
+	Genre string
+	// Year is 0 if unknown.
+	Year int
+	// Path is the album's directory, relative to the fs.FS it was
+	// found in.
+	Path   string
+	Tracks []Track
+	// Duration is the total of the tracks' durations, as far as they
+	// are known.
+	Duration time.Duration
+	HasCover bool
+}
+
+// LocalRoot converts a directory on the local filesystem into the
+// equivalent root within os.DirFS("/").
+func LocalRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if r := strings.TrimPrefix(filepath.ToSlash(abs), "/"); r != "" {
+		return r, nil
+	}
+	return ".", nil
 }
 
 type AlbumIterFn iter.Seq[*AlbumMetadata]
@@ -40,66 +67,325 @@ func AlbumIterator(ch chan *AlbumMetadata) AlbumIterFn {
 	}
 }
 
-type DirectoryReader func(name string) ([]os.DirEntry, error)
+// Options configures a directoryAlbumProducer.
+type Options struct {
+	// Layouts are the layout templates tried, in order, against each
+	// album directory found. Defaults to DefaultLayouts.
+	Layouts []string
+	// Workers bounds the number of directories read, and goroutines
+	// walking them, concurrently. Defaults to DefaultWorkers.
+	Workers int
+	// Index, if set, records scanned albums so that subsequent scans only
+	// yield albums which are new or have changed.
+	Index Index
+	// Full yields every album even if the Index says it is unchanged.
+	Full bool
+	// Probe opens each audio file to find its duration and bitrate.
+	Probe bool
+	// TrackPatterns are the regular expressions tried, in order, against
+	// each track's filename. Defaults to DefaultTrackPatterns.
+	TrackPatterns []string
+	// Settle is how long a Watcher waits for an album directory to stop
+	// changing. Defaults to DefaultSettle.
+	Settle time.Duration
+}
+
+// DefaultWorkers is enough to hide the latency of a network mount without
+// swamping it.
+const DefaultWorkers = 8
+
+// ErrNoLayout is returned (wrapped) for album directories which match
+// none of the configured layouts.
+var ErrNoLayout = errors.New("matches no layout")
 
 type directoryAlbumProducer struct {
-	root    string
-	readDir DirectoryReader
+	roots   []string
+	layouts []*Layout
+	tracks  *trackParser
+	fsys    fs.FS
 	ch      chan *AlbumMetadata
+	sem     chan struct{}
+	// walkers bounds the goroutines walking directories.
+	walkers chan struct{}
+	wg      sync.WaitGroup
+
+	index      Index
+	full       bool
+	probeFiles bool
+	idxMu      sync.Mutex
+	seen       map[string]bool
+	// pending are the albums yielded but not yet Synced, by path.
+	pending map[string]*IndexedAlbum
+
+	mu          sync.Mutex
+	errs        []error
+	failed      []string
+	interrupted bool
+	// onFail, if set, is given errors instead of them being kept for
+	// Err().
+	onFail func(error)
+}
+
+type discDir struct {
+	name string
+	num  int
 }
 
 func (d *directoryAlbumProducer) Albums() AlbumIterFn {
 	return AlbumIterator(d.ch)
 }
 
-func (d *directoryAlbumProducer) Start() {
-	arts, err := d.readDir(d.root)
-	l := log.New(os.Stderr, "dAP: ", log.Ldate|log.Ltime|log.Lshortfile)
+// Err returns the errors encountered while scanning, joined, or nil. It
+// is only complete once Albums() has been exhausted.
+func (d *directoryAlbumProducer) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Join(d.errs...)
+}
+
+func (d *directoryAlbumProducer) fail(err error) {
+	if d.onFail != nil {
+		d.onFail(err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, err)
+}
+
+// failDir records a directory which couldn't be read, so that the albums
+// beneath it aren't reported as having disappeared.
+func (d *directoryAlbumProducer) failDir(dir string, err error) {
+	d.fail(err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failed = append(d.failed, dir)
+}
+
+// Start walks the library roots, yielding an AlbumMetadata for every
+// directory that holds audio files or disc directories. Albums arrive in
+// no particular order. The channel is closed once all roots have been
+// walked or ctx is done, whichever comes first.
+func (d *directoryAlbumProducer) Start(ctx context.Context) {
+	for _, root := range d.roots {
+		d.walkDir(ctx, root, nil)
+	}
+	d.wg.Wait()
+	if err := ctx.Err(); err != nil {
+		d.fail(err)
+		d.mu.Lock()
+		d.interrupted = true
+		d.mu.Unlock()
+	}
+	close(d.ch)
+}
+
+// list reads a directory once a worker slot is free.
+func (d *directoryAlbumProducer) list(ctx context.Context, dir string) ([]fs.DirEntry, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return fs.ReadDir(d.fsys, dir)
+}
+
+// acquire waits for a worker slot; release frees it.
+func (d *directoryAlbumProducer) acquire(ctx context.Context) error {
+	select {
+	case d.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *directoryAlbumProducer) release() {
+	<-d.sem
+}
+
+// walkDir walks a directory in a new goroutine if a walker is free, or
+// else in this one, so that big libraries don't need a goroutine for
+// every directory.
+func (d *directoryAlbumProducer) walkDir(ctx context.Context, root string, rel []string) {
+	d.wg.Add(1)
+	select {
+	case d.walkers <- struct{}{}:
+		go func() {
+			defer func() { <-d.walkers }()
+			d.walk(ctx, root, rel)
+		}()
+	default:
+		d.walk(ctx, root, rel)
+	}
+}
+
+func (d *directoryAlbumProducer) walk(ctx context.Context, root string, rel []string) {
+	defer d.wg.Done()
+	dir := path.Join(append([]string{root}, rel...)...)
+	entries, err := d.list(ctx, dir)
 	if err != nil {
-		l.Println("warn:", err)
-		close(d.ch)
+		if ctx.Err() == nil {
+			d.failDir(dir, err)
+		}
 		return
 	}
-	for _, art := range arts {
-		if !art.IsDir() {
-			l.Printf("warn: %s is not a directory", art.Name())
+	tracks := make([]Track, 0)
+	files := make([]IndexedFile, 0)
+	discs := make([]discDir, 0)
+	cover := false
+	for _, e := range entries {
+		if !e.IsDir() {
+			if isAudioFile(e.Name()) {
+				t := d.tracks.parse(e.Name())
+				if t.Disc == 0 {
+					t.Disc = 1
+				}
+				tracks = append(tracks, t)
+				files = append(files, d.indexedFile(e, e.Name()))
+			}
+			cover = cover || isImageFile(e.Name())
 			continue
 		}
-		albs, err := d.readDir(fmt.Sprintf("%s/%s", d.root, art.Name()))
-		if err != nil {
-			l.Println("warn:", err)
+		if n, ok := discNumber(e.Name()); ok {
+			discs = append(discs, discDir{name: e.Name(), num: n})
 			continue
 		}
-		for _, alb := range albs {
-			if !alb.IsDir() {
-				l.Printf("warn: %s is not a directory", alb.Name())
-				continue
+		d.walkDir(ctx, root, slices.Concat(rel, []string{e.Name()}))
+	}
+	if len(tracks) == 0 && len(discs) == 0 {
+		return
+	}
+	// Merge multi-disc albums in disc order, not name order, so that
+	// CD10 comes after CD9.
+	sort.SliceStable(discs, func(i, j int) bool { return discs[i].num < discs[j].num })
+	for _, disc := range discs {
+		discPath := path.Join(dir, disc.name)
+		dt, err := d.list(ctx, discPath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
-			tracks, err := d.readDir(fmt.Sprintf("%s/%s/%s", d.root, art.Name(), alb.Name()))
-			if err != nil {
-				l.Println("warn:", err)
+			d.failDir(discPath, err)
+			continue
+		}
+		for _, e := range dt {
+			if e.IsDir() {
 				continue
 			}
-			t := make([]string, 0)
-			for _, track := range tracks {
-				t = append(t, extractTrackName(track.Name()))
+			if isAudioFile(e.Name()) {
+				t := d.tracks.parse(e.Name())
+				t.Disc = disc.num
+				t.Filename = path.Join(disc.name, e.Name())
+				tracks = append(tracks, t)
+				files = append(files, d.indexedFile(e, t.Filename))
 			}
-			d.ch <- &AlbumMetadata{
-				Artist: art.Name(),
-				Name:   alb.Name(),
-				Tracks: t,
+			cover = cover || isImageFile(e.Name())
+		}
+	}
+	d.emit(ctx, dir, rel, tracks, cover, files)
+}
+
+func (d *directoryAlbumProducer) indexedFile(e fs.DirEntry, name string) IndexedFile {
+	f := IndexedFile{Name: name}
+	if d.index == nil {
+		return f
+	}
+	info, err := e.Info()
+	if err != nil {
+		d.fail(err)
+		return f
+	}
+	f.Size = info.Size()
+	f.ModTime = info.ModTime()
+	return f
+}
+
+func (d *directoryAlbumProducer) emit(ctx context.Context, dir string, rel []string, tracks []Track, cover bool, files []IndexedFile) {
+	for _, l := range d.layouts {
+		a, ok := l.match(rel)
+		if !ok {
+			continue
+		}
+		a.Path = dir
+		a.Year = parseYear(a.Name)
+		a.Tracks = tracks
+		for i := range a.Tracks {
+			a.Tracks[i].attribute(a.Artist)
+		}
+		a.HasCover = cover
+		if d.index != nil && d.unchanged(dir, files) {
+			return
+		}
+		if d.probeFiles {
+			for i := range a.Tracks {
+				if err := d.acquire(ctx); err != nil {
+					return
+				}
+				err := d.probe(dir, &a.Tracks[i])
+				d.release()
+				if err != nil {
+					d.fail(fmt.Errorf("%s: %w", path.Join(dir, a.Tracks[i].Filename), err))
+				}
 			}
 		}
+		for _, t := range a.Tracks {
+			a.Duration += t.Duration
+		}
+		if d.index != nil {
+			d.hold(&IndexedAlbum{Path: dir, Files: files, Album: a})
+		}
+		select {
+		case d.ch <- a:
+		case <-ctx.Done():
+		}
+		return
 	}
-	close(d.ch)
+	d.fail(fmt.Errorf("%s %w", dir, ErrNoLayout))
 }
 
-func NewDirectoryAlbumProducer(root string, readDir DirectoryReader) *directoryAlbumProducer {
+// NewDirectoryAlbumProducer creates a producer for albums beneath the given
+// roots, which are paths within fsys ("." for all of it). Start() must be
+// called to start producing.
+func NewDirectoryAlbumProducer(fsys fs.FS, roots []string, o Options) (*directoryAlbumProducer, error) {
+	for _, root := range roots {
+		if !fs.ValidPath(root) {
+			return nil, &fs.PathError{Op: "open", Path: root, Err: fs.ErrInvalid}
+		}
+	}
+	templates := o.Layouts
+	if len(templates) == 0 {
+		templates = DefaultLayouts
+	}
+	layouts, err := ParseLayouts(templates)
+	if err != nil {
+		return nil, err
+	}
+	patterns := o.TrackPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultTrackPatterns
+	}
+	tracks, err := newTrackParser(patterns)
+	if err != nil {
+		return nil, err
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
 	ch := make(chan *AlbumMetadata, 20)
 	d := &directoryAlbumProducer{
-		root:    root,
-		readDir: readDir,
-		ch:      ch,
+		roots:      roots,
+		layouts:    layouts,
+		tracks:     tracks,
+		fsys:       fsys,
+		ch:         ch,
+		sem:        make(chan struct{}, workers),
+		walkers:    make(chan struct{}, workers),
+		index:      o.Index,
+		full:       o.Full,
+		probeFiles: o.Probe,
+		seen:       make(map[string]bool),
+		pending:    make(map[string]*IndexedAlbum),
 	}
-	return d
+	return d, nil
 }
@@ -1,9 +1,18 @@
 package media
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
+	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -13,17 +22,17 @@ func TestAlbumIterator(t *testing.T) {
 		AlbumMetadata{
 			Artist: "Artist1",
 			Name:   "Title1",
-			Tracks: []string{
-				"Track1",
-				"Track2",
+			Tracks: []Track{
+				{Title: "Track1"},
+				{Title: "Track2"},
 			},
 		},
 		AlbumMetadata{
 			Artist: "Artist2",
 			Name:   "Title2",
-			Tracks: []string{
-				"Track3",
-				"Track4",
+			Tracks: []Track{
+				{Title: "Track3"},
+				{Title: "Track4"},
 			},
 		},
 	}
@@ -48,25 +57,25 @@ func TestDirectoryAlbumIterator(t *testing.T) {
 		AlbumMetadata{
 			Artist: "Artist1",
 			Name:   "Title1",
-			Tracks: []string{
-				"Track1",
-				"Track2",
+			Tracks: []Track{
+				{Title: "Track1"},
+				{Title: "Track2"},
 			},
 		},
 		AlbumMetadata{
 			Artist: "Artist2 Two",
 			Name:   "Title2 Two",
-			Tracks: []string{
-				"Track3 Three",
-				"Track4",
+			Tracks: []Track{
+				{Title: "Track3 Three"},
+				{Title: "Track4"},
 			},
 		},
 		AlbumMetadata{
 			Artist: "Artist2 Two",
 			Name:   "Title3Three",
-			Tracks: []string{
-				"Track5 Five",
-				"Track6 6",
+			Tracks: []Track{
+				{Title: "Track5 Five"},
+				{Title: "Track6 6"},
 			},
 		},
 	}
@@ -75,6 +84,7 @@ func TestDirectoryAlbumIterator(t *testing.T) {
 		"01 ",
 		"02. ",
 	}
+	numbers := []int{0, 1, 2}
 	suffixes := []string{
 		".mp3",
 		".MP3",
@@ -86,18 +96,26 @@ func TestDirectoryAlbumIterator(t *testing.T) {
 			t.Error(err)
 			continue
 		}
-		for _, track := range alb.Tracks {
-			if _, err := os.Create(fmt.Sprintf("%s/%s/%s/%s%s%s", tmp, alb.Artist, alb.Name, prefixes[i%len(prefixes)], track, suffixes[i%len(suffixes)])); err != nil {
+		for j, track := range alb.Tracks {
+			filename := fmt.Sprintf("%s%s%s", prefixes[i%len(prefixes)], track.Title, suffixes[i%len(suffixes)])
+			if _, err := os.Create(fmt.Sprintf("%s/%s/%s/%s", tmp, alb.Artist, alb.Name, filename)); err != nil {
 				t.Error(err)
 				continue
 			}
+			alb.Tracks[j].Number = numbers[i%len(numbers)]
+			alb.Tracks[j].Disc = 1
+			alb.Tracks[j].Filename = filename
+			alb.Tracks[j].Format = "mp3"
 			i++
 		}
 	}
 
-	d := NewDirectoryAlbumProducer(tmp, os.ReadDir)
+	d, err := NewDirectoryAlbumProducer(os.DirFS(tmp), []string{"."}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	go func() {
-		d.Start()
+		d.Start(context.Background())
 	}()
 	got := make([]AlbumMetadata, len(want))
 	i = 0
@@ -108,7 +126,361 @@ func TestDirectoryAlbumIterator(t *testing.T) {
 	// Because Track1 ends up "Track1.mp3" and Track2 ends up "01.
 	// Track2.MP3" they sort differently. Swap them.
 	want[0].Tracks[0], want[0].Tracks[1] = want[0].Tracks[1], want[0].Tracks[0]
+	for i := range want {
+		want[i].Path = path.Join(want[i].Artist, want[i].Name)
+	}
+	sortAlbums(got)
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("Album mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestDirectoryAlbumLayouts(t *testing.T) {
+	files := []string{
+		"a/Rock/Artist1/Title1/01 Track1.flac",
+		"a/Rock/Artist1/Title1/cover.jpg",
+		"a/Rock/Artist1/artist.jpg",
+		"a/Compilations/Title2/01 Track2.mp3",
+		"a/Compilations/Title2/02 Track3.mp3",
+		"b/Artist2/Title3 (1979)/CD2/01 Track6.mp3",
+		"b/Artist2/Title3 (1979)/CD10/01 Track7.mp3",
+		"b/Artist2/Title3 (1979)/CD1/01 Track4.mp3",
+		"b/Artist2/Title3 (1979)/CD1/02 Track5.mp3",
+		"b/Artist2/Title4/Too/Deep/01 Track8.mp3",
+	}
+	want := []AlbumMetadata{
+		AlbumMetadata{
+			Artist: VariousArtists,
+			Name:   "Title2",
+			Path:   "a/Compilations/Title2",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "Track2", Filename: "01 Track2.mp3", Format: "mp3"},
+				{Number: 2, Disc: 1, Title: "Track3", Filename: "02 Track3.mp3", Format: "mp3"},
+			},
+		},
+		AlbumMetadata{
+			Artist: "Artist1",
+			Name:   "Title1",
+			Genre:  "Rock",
+			Path:   "a/Rock/Artist1/Title1",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "Track1", Filename: "01 Track1.flac", Format: "flac"},
+			},
+			HasCover: true,
+		},
+		AlbumMetadata{
+			Artist: "Artist2",
+			Name:   "Title3 (1979)",
+			Path:   "b/Artist2/Title3 (1979)",
+			Year:   1979,
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "Track4", Filename: "CD1/01 Track4.mp3", Format: "mp3"},
+				{Number: 2, Disc: 1, Title: "Track5", Filename: "CD1/02 Track5.mp3", Format: "mp3"},
+				{Number: 1, Disc: 2, Title: "Track6", Filename: "CD2/01 Track6.mp3", Format: "mp3"},
+				{Number: 1, Disc: 10, Title: "Track7", Filename: "CD10/01 Track7.mp3", Format: "mp3"},
+			},
+		},
+	}
+	fsys := fstest.MapFS{}
+	for _, f := range files {
+		fsys[f] = &fstest.MapFile{}
+	}
+	o := Options{
+		Layouts: []string{
+			"compilations/{album}",
+			"{genre}/{artist}/{album}",
+			"{artist}/{album}/{disc}",
+		},
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"a", "b"}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		d.Start(context.Background())
+	}()
+	got := make([]AlbumMetadata, 0)
+	for a := range d.Albums() {
+		got = append(got, *a)
+	}
+	sortAlbums(want)
+	sortAlbums(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Album mismatch (-want +got):\n%s", diff)
+	}
+	if err := d.Err(); !errors.Is(err, ErrNoLayout) {
+		t.Errorf("d.Err(): got %v, want %v", err, ErrNoLayout)
+	}
+}
+
+func TestDirectoryAlbumCancel(t *testing.T) {
+	tmp := t.TempDir()
+	for i := 0; i < 50; i++ {
+		p := filepath.Join(tmp, fmt.Sprintf("Artist%d", i), "Title", "Track.mp3")
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := NewDirectoryAlbumProducer(os.DirFS(tmp), []string{"."}, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Start(ctx)
+		close(done)
+	}()
+	for range d.Albums() {
+		break
+	}
+	cancel()
+	<-done
+	if err := d.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("d.Err(): got %v, want %v", err, context.Canceled)
+	}
+}
+
+// countingFS records the most goroutines running while reading
+// directories.
+type countingFS struct {
+	fstest.MapFS
+	mu  *sync.Mutex
+	max *int
+}
+
+func (c countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.mu.Lock()
+	*c.max = max(*c.max, runtime.NumGoroutine())
+	c.mu.Unlock()
+	return c.MapFS.ReadDir(name)
+}
+
+func TestDirectoryAlbumGoroutines(t *testing.T) {
+	fsys := countingFS{MapFS: fstest.MapFS{}, mu: &sync.Mutex{}, max: new(int)}
+	for i := 0; i < 200; i++ {
+		fsys.MapFS[fmt.Sprintf("Artist%d/Title/Track.mp3", i)] = &fstest.MapFile{}
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"."}, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	go func() {
+		d.Start(context.Background())
+	}()
+	n := 0
+	for range d.Albums() {
+		n++
+	}
+	if n != 200 {
+		t.Errorf("got %d albums, want 200", n)
+	}
+	// Start's own goroutine, and the workers.
+	if got := *fsys.max - before; got > 1+2 {
+		t.Errorf("got %d goroutines walking, want at most 3", got)
+	}
+}
+
+// brokenFS fails to read the named directory.
+type brokenFS struct {
+	fstest.MapFS
+	broken string
+	err    error
+}
+
+func (b brokenFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == b.broken {
+		return nil, b.err
+	}
+	return b.MapFS.ReadDir(name)
+}
+
+func TestDirectoryAlbumReadError(t *testing.T) {
+	fsys := brokenFS{
+		MapFS: fstest.MapFS{
+			"Artist1/Title1/Track1.mp3": &fstest.MapFile{},
+			"Artist2/Title2/Track2.mp3": &fstest.MapFile{},
+		},
+		broken: "Artist2",
+		err:    errors.New("broken mount"),
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"."}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		d.Start(context.Background())
+	}()
+	n := 0
+	for range d.Albums() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %d albums, want 1", n)
+	}
+	if err := d.Err(); !errors.Is(err, fsys.err) {
+		t.Errorf("d.Err(): got %v, want %v", err, fsys.err)
+	}
+}
+
+func sortAlbums(albums []AlbumMetadata) {
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Artist != albums[j].Artist {
+			return albums[i].Artist < albums[j].Artist
+		}
+		return albums[i].Name < albums[j].Name
+	})
+}
+
+func TestNewDirectoryAlbumProducerInvalidRoot(t *testing.T) {
+	for _, root := range []string{"/usr/local/mp3", "../mp3", "mp3/"} {
+		if _, err := NewDirectoryAlbumProducer(fstest.MapFS{}, []string{root}, Options{}); err == nil {
+			t.Errorf("NewDirectoryAlbumProducer(..., %q, ...): got nil error", root)
+		}
+	}
+}
+
+func TestParseTrack(t *testing.T) {
+	cases := []struct {
+		filename string
+		want     Track
+	}{
+		{
+			filename: "01. Title.mp3",
+			want:     Track{Number: 1, Title: "Title", Filename: "01. Title.mp3", Format: "mp3"},
+		},
+		{
+			filename: "12 Title Two.FLAC",
+			want:     Track{Number: 12, Title: "Title Two", Filename: "12 Title Two.FLAC", Format: "flac"},
+		},
+		{
+			filename: "Title.ogg",
+			want:     Track{Title: "Title", Filename: "Title.ogg", Format: "ogg"},
+		},
+		{
+			filename: "10_000 Maniacs.mp3",
+			want:     Track{Title: "10 000 Maniacs", Filename: "10_000 Maniacs.mp3", Format: "mp3"},
+		},
+		{
+			filename: "1-03 Artist - Title.mp3",
+			want:     Track{Number: 3, Disc: 1, Artist: "Artist", Title: "Title", Filename: "1-03 Artist - Title.mp3", Format: "mp3"},
+		},
+		{
+			filename: "2-11 Title.flac",
+			want:     Track{Number: 11, Disc: 2, Title: "Title", Filename: "2-11 Title.flac", Format: "flac"},
+		},
+		{
+			filename: "A1 Title.mp3",
+			want:     Track{Number: 1, Title: "Title", Filename: "A1 Title.mp3", Format: "mp3"},
+		},
+		{
+			filename: "03_title_(remix).mp3",
+			want:     Track{Number: 3, Title: "title (remix)", Filename: "03_title_(remix).mp3", Format: "mp3"},
+		},
+		{
+			filename: "04 - Some.Title.mp3",
+			want:     Track{Number: 4, Title: "Some Title", Filename: "04 - Some.Title.mp3", Format: "mp3"},
+		},
+		{
+			filename: "05 Mr. Title.mp3",
+			want:     Track{Number: 5, Title: "Mr. Title", Filename: "05 Mr. Title.mp3", Format: "mp3"},
+		},
+		{
+			filename: "Track 07.mp3",
+			want:     Track{Title: "Track 07", Placeholder: true, Filename: "Track 07.mp3", Format: "mp3"},
+		},
+		{
+			filename: "07 Track07.wav",
+			want:     Track{Number: 7, Title: "Track07", Placeholder: true, Filename: "07 Track07.wav", Format: "wav"},
+		},
+	}
+	p, err := newTrackParser(DefaultTrackPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		if diff := cmp.Diff(tc.want, p.parse(tc.filename)); diff != "" {
+			t.Errorf("parse(%q) mismatch (-want +got):\n%s", tc.filename, diff)
+		}
+	}
+}
+
+func TestNewTrackParser(t *testing.T) {
+	if _, err := newTrackParser([]string{`^(?P<track>[0-9]+)`}); err == nil {
+		t.Error("pattern without a title group: got nil err")
+	}
+	if _, err := newTrackParser([]string{`^(?P<title>.+`}); err == nil {
+		t.Error("invalid pattern: got nil err")
+	}
+	p, err := newTrackParser([]string{`^(?P<title>.+) \((?P<track>[0-9]+)\)$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Track{Number: 4, Title: "Title", Filename: "Title (4).mp3", Format: "mp3"}
+	if diff := cmp.Diff(want, p.parse("Title (4).mp3")); diff != "" {
+		t.Errorf("parse mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	cases := []struct {
+		album      string
+		track      Track
+		wantTitle  string
+		wantArtist string
+	}{
+		{album: "Artist", track: Track{Artist: "artist", Title: "Title"}, wantTitle: "Title"},
+		{album: "Artist", track: Track{Artist: "Title", Title: "Live"}, wantTitle: "Title - Live"},
+		{album: VariousArtists, track: Track{Artist: "Other", Title: "Title"}, wantTitle: "Title", wantArtist: "Other"},
+		{album: "Artist", track: Track{Title: "Title"}, wantTitle: "Title"},
+	}
+	for _, tc := range cases {
+		tc.track.attribute(tc.album)
+		if tc.track.Title != tc.wantTitle || tc.track.Artist != tc.wantArtist {
+			t.Errorf("attribute(%q): got %q by %q, want %q by %q", tc.album, tc.track.Title, tc.track.Artist, tc.wantTitle, tc.wantArtist)
+		}
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	cases := map[string]int{
+		"1979 - Live":                1979,
+		"Live (2003)":                2003,
+		"Live [1999 Remaster]":       1999,
+		"Live! - (CD1_ Boston 1979)": 1979,
+		"1999":                       1999,
+		"10000 Maniacs":              0,
+		"Title":                      0,
+	}
+	for name, want := range cases {
+		if got := parseYear(name); got != want {
+			t.Errorf("parseYear(%q): got %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	cases := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: "{artist}/{album}"},
+		{template: "{genre}/{artist}/{album}/{disc}"},
+		{template: "Compilations/{album}"},
+		{template: "{artist}", wantErr: true},
+		{template: "{artist}/{disc}/{album}", wantErr: true},
+		{template: "{artist}/{year}/{album}", wantErr: true},
+		{template: "{album}/{album}", wantErr: true},
+		{template: "{artist}//{album}", wantErr: true},
+	}
+	for _, tc := range cases {
+		_, err := ParseLayout(tc.template)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseLayout(%q): got err %v, wantErr %v", tc.template, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var playlistExtensions = []string{".m3u", ".m3u8", ".pls"}
+
+// IsPlaylistFile reports whether filename looks like an M3U or PLS
+// playlist.
+func IsPlaylistFile(filename string) bool {
+	return slices.Contains(playlistExtensions, strings.ToLower(path.Ext(filename)))
+}
+
+// Playlist is a playlist file from the local collection.
+type Playlist struct {
+	// Name is the file's name less its extension.
+	Name string
+	// Path is the playlist file, relative to the fs.FS it was read from.
+	Path    string
+	Entries []PlaylistEntry
+}
+
+// PlaylistEntry is a single entry of a Playlist.
+type PlaylistEntry struct {
+	// Location is the entry as written in the playlist.
+	Location string
+	// Title and Duration are from the playlist's own metadata, if it
+	// has any.
+	Title    string
+	Duration time.Duration
+	// Path is the file within the fs.FS, or "" if the entry couldn't
+	// be resolved to one.
+	Path string
+}
+
+// FindPlaylists returns the playlist files beneath roots, which are paths
+// within fsys.
+func FindPlaylists(fsys fs.FS, roots []string) ([]string, error) {
+	found := make([]string, 0)
+	for _, root := range roots {
+		err := fs.WalkDir(fsys, root, func(p string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !e.IsDir() && IsPlaylistFile(p) {
+				found = append(found, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// ReadPlaylist reads the M3U, M3U8 or PLS playlist at name within fsys,
+// resolving its entries to files within fsys. Relative entries are taken
+// to be relative to the playlist; absolute ones only resolve if fsys is
+// os.DirFS("/"). Files which aren't valid UTF-8 are read as Latin-1, as
+// older players wrote .m3u files.
+func ReadPlaylist(fsys fs.FS, name string) (*Playlist, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	text := string(b)
+	if !utf8.Valid(b) {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		text = string(runes)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	pl := &Playlist{
+		Name: strings.TrimSuffix(path.Base(name), path.Ext(name)),
+		Path: name,
+	}
+	switch ext {
+	case ".m3u", ".m3u8":
+		pl.Entries = parseM3U(text)
+	case ".pls":
+		var skipped []int
+		pl.Entries, skipped = parsePLS(text)
+		for _, n := range skipped {
+			log.Printf("warn: %s: skipping entry %d, which has no file", name, n)
+		}
+	default:
+		return nil, fmt.Errorf("%s: not a playlist", name)
+	}
+	dir := path.Dir(name)
+	for i := range pl.Entries {
+		pl.Entries[i].Path = resolveEntry(fsys, dir, pl.Entries[i].Location)
+	}
+	return pl, nil
+}
+
+func parseM3U(text string) []PlaylistEntry {
+	entries := make([]PlaylistEntry, 0)
+	var info PlaylistEntry
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<title>
+			secs, title, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			info.Title = strings.TrimSpace(title)
+			// Attributes may follow the duration, space separated.
+			secs, _, _ = strings.Cut(secs, " ")
+			if n, err := strconv.ParseFloat(secs, 64); err == nil && n > 0 {
+				info.Duration = time.Duration(n * float64(time.Second))
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			info.Location = line
+			entries = append(entries, info)
+			info = PlaylistEntry{}
+		}
+	}
+	return entries
+}
+
+// parsePLS returns the entries of a PLS playlist in order, and the numbers
+// of any skipped for having no file.
+func parsePLS(text string) ([]PlaylistEntry, []int) {
+	byNum := make(map[int]*PlaylistEntry)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(k))
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue
+		}
+		e := byNum[n]
+		if e == nil {
+			e = &PlaylistEntry{}
+			byNum[n] = e
+		}
+		v = strings.TrimSpace(v)
+		switch field {
+		case "file":
+			e.Location = v
+		case "title":
+			e.Title = v
+		case "length":
+			if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+				e.Duration = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	nums := make([]int, 0, len(byNum))
+	skipped := make([]int, 0)
+	for n, e := range byNum {
+		if e.Location == "" {
+			skipped = append(skipped, n)
+			continue
+		}
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	sort.Ints(skipped)
+	entries := make([]PlaylistEntry, len(nums))
+	for i, n := range nums {
+		entries[i] = *byNum[n]
+	}
+	return entries, skipped
+}
+
+// resolveEntry finds the file within fsys that a playlist in dir means by
+// loc, returning "" if there is none.
+func resolveEntry(fsys fs.FS, dir string, loc string) string {
+	if strings.Contains(loc, "://") {
+		p, ok := fileURLPath(loc)
+		if !ok {
+			return ""
+		}
+		loc = "/" + p
+	}
+	loc = strings.ReplaceAll(loc, "\\", "/")
+	var p string
+	if strings.HasPrefix(loc, "/") {
+		p = strings.TrimPrefix(path.Clean(loc), "/")
+	} else {
+		p = path.Join(dir, loc)
+	}
+	if !fs.ValidPath(p) {
+		return ""
+	}
+	if info, err := fs.Stat(fsys, p); err != nil || info.IsDir() {
+		return ""
+	}
+	return p
+}
+
+// fileURLPath converts a file:// URL to a path within os.DirFS("/").
+func fileURLPath(loc string) (string, bool) {
+	scheme, rest, ok := strings.Cut(loc, "://")
+	if !ok || !strings.EqualFold(scheme, "file") {
+		return "", false
+	}
+	// file:///music/a.mp3, or file://localhost/music/a.mp3
+	_, rest, _ = strings.Cut(rest, "/")
+	p, err := url.PathUnescape(rest)
+	if err != nil || !fs.ValidPath(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// Resolve reads what it can about the audio file at p, which must be
+// beneath one of the producer's roots. It returns the album the file
+// belongs to, with just the one track. If the file can't be probed, the
+// album is returned along with the error.
+func (d *directoryAlbumProducer) Resolve(p string) (*AlbumMetadata, error) {
+	dir, file := path.Dir(p), path.Base(p)
+	disc := 0
+	if n, ok := discNumber(path.Base(dir)); ok {
+		disc = n
+		file = path.Join(path.Base(dir), file)
+		dir = path.Dir(dir)
+	}
+	for _, root := range d.roots {
+		if !underAny(dir, []string{root}) {
+			continue
+		}
+		r := dir
+		if root := path.Clean(root); root != "." {
+			r = strings.TrimPrefix(strings.TrimPrefix(dir, root), "/")
+		}
+		rel := make([]string, 0)
+		if r != "" && r != "." {
+			rel = strings.Split(r, "/")
+		}
+		for _, l := range d.layouts {
+			a, ok := l.match(rel)
+			if !ok {
+				continue
+			}
+			t := d.tracks.parse(path.Base(file))
+			t.Filename = file
+			if disc > 0 {
+				t.Disc = disc
+			} else if t.Disc == 0 {
+				t.Disc = 1
+			}
+			t.attribute(a.Artist)
+			var err error
+			if d.probeFiles {
+				if perr := d.probe(dir, &t); perr != nil {
+					err = fmt.Errorf("%s: %w", p, perr)
+				}
+			}
+			a.Path = dir
+			a.Year = parseYear(a.Name)
+			a.Tracks = []Track{t}
+			a.Duration = t.Duration
+			return a, err
+		}
+		return nil, fmt.Errorf("%s %w", dir, ErrNoLayout)
+	}
+	return nil, fmt.Errorf("%s: not beneath any library root", p)
+}
+
+// WritePlaylist writes pl to w as an extended M3U playlist, for which
+// UTF-8 (.m3u8) is assumed. Entries are written by their Location.
+func WritePlaylist(w io.Writer, pl *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	fmt.Fprintln(bw, "#PLAYLIST:"+pl.Name)
+	for _, e := range pl.Entries {
+		secs := -1
+		if e.Duration > 0 {
+			secs = int(e.Duration.Round(time.Second).Seconds())
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", secs, e.Title)
+		fmt.Fprintln(bw, e.Location)
+	}
+	return bw.Flush()
+}
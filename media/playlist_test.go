@@ -0,0 +1,174 @@
+package media
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadPlaylist(t *testing.T) {
+	fsys := fstest.MapFS{
+		"music/Artist/Title/01 One.mp3":       {Data: []byte("1")},
+		"music/Artist/Title/02 Two.mp3":       {Data: []byte("2")},
+		"music/Artist/Other/CD2/01 Three.mp3": {Data: []byte("3")},
+		"music/lists/mix.m3u8": {Data: []byte("\xef\xbb\xbf#EXTM3U\r\n" +
+			"#EXTINF:201,Artist - One\r\n" +
+			"../Artist/Title/01 One.mp3\r\n" +
+			"\r\n" +
+			"/music/Artist/Title/02 Two.mp3\r\n" +
+			"#EXTINF:-1,Gone\r\n" +
+			"../Artist/Title/03 Gone.mp3\r\n" +
+			"http://example.com/stream.mp3\r\n" +
+			"file:///music/Artist/Other/CD2/01%20Three.mp3\r\n")},
+		"music/lists/old.m3u": {Data: []byte("..\\Artist\\Title\\01 One.mp3\n#EXTINF:10,Caf\xe9\nnowhere.mp3\n")},
+		"music/lists/radio.pls": {Data: []byte("[playlist]\n" +
+			"File2=../Artist/Title/02 Two.mp3\n" +
+			"Title1=One\n" +
+			"File1=../Artist/Title/01 One.mp3\n" +
+			"Length1=201\n" +
+			"NumberOfEntries=2\n")},
+		"music/lists/bad.pls": {Data: []byte("[playlist]\nTitle1=One\nFile2=../Artist/Title/02 Two.mp3\n")},
+	}
+	cases := []struct {
+		name    string
+		want    []PlaylistEntry
+		wantErr bool
+	}{
+		{
+			name: "music/lists/mix.m3u8",
+			want: []PlaylistEntry{
+				{Location: "../Artist/Title/01 One.mp3", Title: "Artist - One", Duration: 201 * time.Second, Path: "music/Artist/Title/01 One.mp3"},
+				{Location: "/music/Artist/Title/02 Two.mp3", Path: "music/Artist/Title/02 Two.mp3"},
+				{Location: "../Artist/Title/03 Gone.mp3", Title: "Gone"},
+				{Location: "http://example.com/stream.mp3"},
+				{Location: "file:///music/Artist/Other/CD2/01%20Three.mp3", Path: "music/Artist/Other/CD2/01 Three.mp3"},
+			},
+		},
+		{
+			name: "music/lists/old.m3u",
+			want: []PlaylistEntry{
+				{Location: "..\\Artist\\Title\\01 One.mp3", Path: "music/Artist/Title/01 One.mp3"},
+				{Location: "nowhere.mp3", Title: "Café", Duration: 10 * time.Second},
+			},
+		},
+		{
+			name: "music/lists/radio.pls",
+			want: []PlaylistEntry{
+				{Location: "../Artist/Title/01 One.mp3", Title: "One", Duration: 201 * time.Second, Path: "music/Artist/Title/01 One.mp3"},
+				{Location: "../Artist/Title/02 Two.mp3", Path: "music/Artist/Title/02 Two.mp3"},
+			},
+		},
+		{
+			// Entries with no file are skipped.
+			name: "music/lists/bad.pls",
+			want: []PlaylistEntry{
+				{Location: "../Artist/Title/02 Two.mp3", Path: "music/Artist/Title/02 Two.mp3"},
+			},
+		},
+		{name: "music/lists/missing.m3u", wantErr: true},
+	}
+	for _, tc := range cases {
+		pl, err := ReadPlaylist(fsys, tc.name)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ReadPlaylist(%q): got err %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if diff := cmp.Diff(tc.want, pl.Entries); diff != "" {
+			t.Errorf("ReadPlaylist(%q) mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+
+	found, err := FindPlaylists(fsys, []string{"music"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"music/lists/bad.pls", "music/lists/mix.m3u8", "music/lists/old.m3u", "music/lists/radio.pls"}
+	if diff := cmp.Diff(want, found); diff != "" {
+		t.Errorf("FindPlaylists mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	fsys := fstest.MapFS{
+		"music/Artist/Title (1999)/03 Three.mp3": {},
+		"music/Artist/Other/CD2/01 One.mp3":      {},
+		"music/Loose.mp3":                        {},
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"music"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path    string
+		want    *AlbumMetadata
+		wantErr bool
+	}{
+		{
+			path: "music/Artist/Title (1999)/03 Three.mp3",
+			want: &AlbumMetadata{
+				Artist: "Artist",
+				Name:   "Title (1999)",
+				Year:   1999,
+				Path:   "music/Artist/Title (1999)",
+				Tracks: []Track{{Number: 3, Disc: 1, Title: "Three", Filename: "03 Three.mp3", Format: "mp3"}},
+			},
+		},
+		{
+			path: "music/Artist/Other/CD2/01 One.mp3",
+			want: &AlbumMetadata{
+				Artist: "Artist",
+				Name:   "Other",
+				Path:   "music/Artist/Other",
+				Tracks: []Track{{Number: 1, Disc: 2, Title: "One", Filename: "CD2/01 One.mp3", Format: "mp3"}},
+			},
+		},
+		{path: "music/Loose.mp3", wantErr: true},
+		{path: "elsewhere/Artist/Title/01 One.mp3", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := d.Resolve(tc.path)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Resolve(%q): got err %v, wantErr %v", tc.path, err, tc.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Resolve(%q) mismatch (-want +got):\n%s", tc.path, diff)
+		}
+	}
+}
+
+func TestWritePlaylist(t *testing.T) {
+	pl := &Playlist{
+		Name: "Mix",
+		Entries: []PlaylistEntry{
+			{Location: "/music/Artist/Title/01 One.mp3", Title: "Artist - One", Duration: 200600 * time.Millisecond},
+			{Location: "/music/Artist/Title/02 Two.mp3", Title: "Artist - Two"},
+		},
+	}
+	var b strings.Builder
+	if err := WritePlaylist(&b, pl); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#PLAYLIST:Mix\n" +
+		"#EXTINF:201,Artist - One\n/music/Artist/Title/01 One.mp3\n" +
+		"#EXTINF:-1,Artist - Two\n/music/Artist/Title/02 Two.mp3\n"
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("WritePlaylist mismatch (-want +got):\n%s", diff)
+	}
+	got := parseM3U(b.String())
+	for i := range got {
+		got[i].Duration = 0
+	}
+	for i := range pl.Entries {
+		pl.Entries[i].Duration = 0
+	}
+	if diff := cmp.Diff(pl.Entries, got); diff != "" {
+		t.Errorf("parseM3U(WritePlaylist) mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,314 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"time"
+)
+
+// ErrUnknownFormat is returned when probing files whose format isn't
+// understood.
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+const (
+	// mp3Window is how far past any ID3v2 tag to look for the first
+	// frame.
+	mp3Window = 64 * 1024
+	id3v2Len  = 10
+)
+
+var (
+	mp3Bitrates = [2][3][16]int{
+		// MPEG 1, layers I, II, III.
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+		},
+		// MPEG 2 and 2.5, layers I, II, III.
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+		},
+	}
+	// mp3SampleRates is indexed by the version bits of the header.
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{0, 0, 0},             // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+)
+
+// audioInfo is what probing an audio file finds out.
+type audioInfo struct {
+	duration time.Duration
+	bitrate  int // kbit/s
+	// rating (0-100) and plays are from an ID3v2 POPM frame, if any.
+	rating int
+	plays  int
+}
+
+// probe fills in the duration and bitrate of t, which must be one of the
+// files of the album in dir, along with any rating and play count tagged.
+func (d *directoryAlbumProducer) probe(dir string, t *Track) error {
+	f, err := d.fsys.Open(path.Join(dir, t.Filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ai, err := probeAudio(f, info.Size(), t.Format)
+	t.Duration, t.Bitrate = ai.duration, ai.bitrate
+	if ai.rating > 0 {
+		t.Rating = ai.rating
+	}
+	if ai.plays > 0 {
+		t.Plays = ai.plays
+	}
+	return err
+}
+
+// probeAudio reads the duration and bitrate of an audio file from its
+// headers, without decoding it.
+func probeAudio(f io.Reader, size int64, format string) (audioInfo, error) {
+	r := bufio.NewReaderSize(f, mp3Window)
+	start, ai, err := readID3v2(r)
+	if err != nil {
+		return ai, err
+	}
+	var dur time.Duration
+	switch format {
+	case "mp3":
+		dur, err = probeMP3(r, size, start)
+	case "flac":
+		dur, err = probeFLAC(r)
+	default:
+		return ai, ErrUnknownFormat
+	}
+	if err != nil || dur <= 0 {
+		return ai, err
+	}
+	ai.duration = dur
+	ai.bitrate = int(float64(size-start) * 8 / dur.Seconds() / 1000)
+	return ai, nil
+}
+
+// readID3v2 reads any ID3v2 tag at the start of r, returning the offset of
+// what follows and the popularity it records. Both MP3 and (against the
+// spec) FLAC files may have one.
+func readID3v2(r *bufio.Reader) (int64, audioInfo, error) {
+	var ai audioInfo
+	h, err := r.Peek(id3v2Len)
+	if err != nil {
+		return 0, ai, err
+	}
+	if !bytes.HasPrefix(h, []byte("ID3")) {
+		return 0, ai, nil
+	}
+	version := h[3]
+	flags := h[5]
+	// The size is "syncsafe": 7 bits per byte.
+	n := int64(syncsafe(h[6:10]))
+	if _, err := r.Discard(id3v2Len); err != nil {
+		return 0, ai, err
+	}
+	tag := make([]byte, n)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return 0, ai, err
+	}
+	n += id3v2Len
+	if flags&0x10 != 0 {
+		if _, err := r.Discard(id3v2Len); err != nil {
+			return 0, ai, err
+		}
+		n += id3v2Len // footer
+	}
+	// Unsynchronised tags would need undoing first, and are rare enough
+	// to not bother.
+	if flags&0x80 == 0 {
+		ai.rating, ai.plays = id3Popularity(tag, version)
+	}
+	return n, ai, nil
+}
+
+func syncsafe(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<7 | int(c&0x7f)
+	}
+	return n
+}
+
+// id3Popularity finds the first POPM (or in ID3v2.2, POP) frame of a tag,
+// returning its rating scaled to 0-100 and its play count.
+func id3Popularity(tag []byte, version byte) (int, int) {
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(tag) >= hdrLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[4:]))
+		default:
+			size = syncsafe(tag[4:8])
+		}
+		if size < 0 || hdrLen+size > len(tag) {
+			break
+		}
+		body := tag[hdrLen : hdrLen+size]
+		tag = tag[hdrLen+size:]
+		if id != "POPM" && id != "POP" {
+			continue
+		}
+		// Email, NUL, rating, then an optional counter of 4+ bytes.
+		i := bytes.IndexByte(body, 0)
+		if i < 0 || i+1 >= len(body) {
+			break
+		}
+		plays := 0
+		for _, c := range body[i+2:] {
+			plays = plays<<8 | int(c)
+		}
+		return popmRating(body[i+1]), plays
+	}
+	return 0, 0
+}
+
+// popmRating converts a POPM rating (1-255) to 0-100, by the star ranges
+// Windows Media Player and most taggers use.
+func popmRating(b byte) int {
+	switch {
+	case b == 0:
+		return 0
+	case b < 32:
+		return 20
+	case b < 96:
+		return 40
+	case b < 160:
+		return 60
+	case b < 224:
+		return 80
+	default:
+		return 100
+	}
+}
+
+func probeMP3(r *bufio.Reader, size int64, start int64) (time.Duration, error) {
+	buf, err := r.Peek(mp3Window)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		h, ok := parseMP3Header(buf[i:])
+		if !ok {
+			continue
+		}
+		if frames, ok := h.vbrFrames(buf[i:]); ok {
+			return time.Duration(frames) * time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate), nil
+		}
+		// Constant bitrate, so the size gives the duration.
+		audio := size - start - int64(i)
+		return time.Duration(audio*8*1000/int64(h.bitrate)) * time.Microsecond, nil
+	}
+	return 0, ErrUnknownFormat
+}
+
+type mp3Header struct {
+	mpeg1      bool
+	mono       bool
+	bitrate    int // kbit/s
+	sampleRate int
+	samples    int // per frame
+}
+
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	var h mp3Header
+	version := b[1] >> 3 & 3
+	layer := 3 - int(b[1]>>1&3) // 0 for layer I
+	if version == 1 || layer == 3 {
+		return h, false
+	}
+	h.mpeg1 = version == 3
+	v := 1
+	if h.mpeg1 {
+		v = 0
+	}
+	h.bitrate = mp3Bitrates[v][layer][b[2]>>4]
+	sr := b[2] >> 2 & 3
+	if h.bitrate <= 0 || sr == 3 {
+		return h, false
+	}
+	h.sampleRate = mp3SampleRates[version][sr]
+	h.mono = b[3]>>6 == 3
+	switch {
+	case layer == 0:
+		h.samples = 384
+	case layer == 2 && !h.mpeg1:
+		h.samples = 576
+	default:
+		h.samples = 1152
+	}
+	return h, true
+}
+
+// vbrFrames returns the frame count from a Xing/Info or VBRI header in the
+// first frame, if it has one.
+func (h mp3Header) vbrFrames(frame []byte) (uint32, bool) {
+	// The Xing header follows the side information, whose size depends
+	// on the version and channels.
+	side := 17
+	switch {
+	case h.mpeg1 && !h.mono:
+		side = 32
+	case !h.mpeg1 && h.mono:
+		side = 9
+	}
+	if x := 4 + side; len(frame) >= x+12 {
+		tag := string(frame[x : x+4])
+		flags := binary.BigEndian.Uint32(frame[x+4:])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			return binary.BigEndian.Uint32(frame[x+8:]), true
+		}
+	}
+	// VBRI headers are always 32 bytes after the frame header.
+	if v := 4 + 32; len(frame) >= v+18 && string(frame[v:v+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[v+14:]), true
+	}
+	return 0, false
+}
+
+func probeFLAC(r *bufio.Reader) (time.Duration, error) {
+	// "fLaC", then the STREAMINFO block which must come first.
+	b := make([]byte, 4+4+34)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	if string(b[:4]) != "fLaC" || b[4]&0x7f != 0 {
+		return 0, ErrUnknownFormat
+	}
+	si := b[8:]
+	bits := binary.BigEndian.Uint64(si[10:])
+	rate := bits >> 44
+	samples := bits & (1<<36 - 1)
+	if rate == 0 {
+		return 0, ErrUnknownFormat
+	}
+	return time.Duration(samples) * time.Second / time.Duration(rate), nil
+}
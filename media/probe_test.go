@@ -0,0 +1,226 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// mpeg1L3 is the header of an MPEG 1 layer III, 128kbit/s, 44.1kHz,
+// stereo frame.
+var mpeg1L3 = []byte{0xff, 0xfb, 0x90, 0x00}
+
+func id3v2(n int) []byte {
+	b := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(n >> 7), byte(n & 0x7f)}
+	return append(b, make([]byte, n)...)
+}
+
+func cbrMP3(audio int) []byte {
+	b := id3v2(100)
+	b = append(b, mpeg1L3...)
+	return append(b, make([]byte, audio-len(mpeg1L3))...)
+}
+
+func xingMP3(frames uint32) []byte {
+	b := append([]byte{}, mpeg1L3...)
+	b = append(b, make([]byte, 32)...)
+	b = append(b, "Xing"...)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, frames)
+	return append(b, make([]byte, 1000)...)
+}
+
+func vbriMP3(frames uint32) []byte {
+	b := append([]byte{}, mpeg1L3...)
+	b = append(b, make([]byte, 32)...)
+	b = append(b, "VBRI"...)
+	b = append(b, make([]byte, 10)...)
+	b = binary.BigEndian.AppendUint32(b, frames)
+	return append(b, make([]byte, 1000)...)
+}
+
+func flac(rate uint64, samples uint64) []byte {
+	b := []byte("fLaC")
+	b = append(b, 0x80, 0, 0, 34)
+	b = append(b, make([]byte, 10)...)
+	b = binary.BigEndian.AppendUint64(b, rate<<44|1<<41|15<<36|samples)
+	return append(b, make([]byte, 16)...)
+}
+
+func TestProbeAudio(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    []byte
+		format  string
+		want    time.Duration
+		wantErr error
+	}{
+		{
+			name:   "cbr mp3",
+			data:   cbrMP3(160000),
+			format: "mp3",
+			want:   10 * time.Second,
+		},
+		{
+			name:   "xing mp3",
+			data:   xingMP3(1000),
+			format: "mp3",
+			want:   1000 * 1152 * time.Second / 44100,
+		},
+		{
+			name:   "vbri mp3",
+			data:   vbriMP3(2000),
+			format: "mp3",
+			want:   2000 * 1152 * time.Second / 44100,
+		},
+		{
+			name:   "flac",
+			data:   flac(44100, 441000),
+			format: "flac",
+			want:   10 * time.Second,
+		},
+		{
+			name:   "flac with id3",
+			data:   append(id3v2(20), flac(48000, 96000)...),
+			format: "flac",
+			want:   2 * time.Second,
+		},
+		{
+			name:    "not an mp3",
+			data:    bytes.Repeat([]byte("junk"), 100),
+			format:  "mp3",
+			wantErr: ErrUnknownFormat,
+		},
+		{
+			name:    "ogg",
+			data:    bytes.Repeat([]byte("OggS"), 100),
+			format:  "ogg",
+			wantErr: ErrUnknownFormat,
+		},
+	}
+	for _, tc := range cases {
+		ai, err := probeAudio(bytes.NewReader(tc.data), int64(len(tc.data)), tc.format)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: got err %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if got := ai.duration; got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProbeBitrate(t *testing.T) {
+	data := cbrMP3(160000)
+	ai, err := probeAudio(bytes.NewReader(data), int64(len(data)), "mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ai.bitrate; got != 128 {
+		t.Errorf("got %dkbit/s, want 128", got)
+	}
+}
+
+func TestDirectoryAlbumProbe(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Artist/Title/01 Track1.mp3":  &fstest.MapFile{Data: cbrMP3(160000)},
+		"Artist/Title/02 Track2.flac": &fstest.MapFile{Data: flac(44100, 44100*5)},
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"."}, Options{Probe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		d.Start(context.Background())
+	}()
+	for a := range d.Albums() {
+		if a.Duration != 15*time.Second {
+			t.Errorf("album duration: got %v, want %v", a.Duration, 15*time.Second)
+		}
+		if a.Tracks[0].Bitrate != 128 {
+			t.Errorf("track bitrate: got %d, want 128", a.Tracks[0].Bitrate)
+		}
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("d.Err(): %v", err)
+	}
+}
+
+// popmTag builds an ID3v2 tag of the given version holding a text frame
+// then a POPM frame.
+func popmTag(version byte, rating byte, plays uint32) []byte {
+	frame := func(id string, body []byte) []byte {
+		n := len(body)
+		b := []byte(id)
+		switch version {
+		case 2:
+			b = append(b, byte(n>>16), byte(n>>8), byte(n))
+		case 3:
+			b = binary.BigEndian.AppendUint32(b, uint32(n))
+		default:
+			b = append(b, byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f))
+		}
+		if version > 2 {
+			b = append(b, 0, 0)
+		}
+		return append(b, body...)
+	}
+	textID, popmID := "TIT2", "POPM"
+	if version == 2 {
+		textID, popmID = "TT2", "POP"
+	}
+	body := append([]byte("someone@example.com\x00"), rating)
+	body = binary.BigEndian.AppendUint32(body, plays)
+	tag := append(frame(textID, []byte("\x00Title")), frame(popmID, body)...)
+	tag = append(tag, make([]byte, 16)...) // padding
+	n := len(tag)
+	h := []byte{'I', 'D', '3', version, 0, 0, 0, 0, byte(n >> 7), byte(n & 0x7f)}
+	return append(h, tag...)
+}
+
+func TestProbePopularity(t *testing.T) {
+	cases := []struct {
+		version    byte
+		rating     byte
+		wantRating int
+	}{
+		{version: 4, rating: 255, wantRating: 100},
+		{version: 3, rating: 196, wantRating: 80},
+		{version: 2, rating: 1, wantRating: 20},
+		{version: 4, rating: 0, wantRating: 0},
+	}
+	for _, tc := range cases {
+		data := append(popmTag(tc.version, tc.rating, 300), flac(44100, 44100)...)
+		ai, err := probeAudio(bytes.NewReader(data), int64(len(data)), "flac")
+		if err != nil {
+			t.Errorf("v2.%d: %v", tc.version, err)
+			continue
+		}
+		if ai.rating != tc.wantRating || ai.plays != 300 || ai.duration != time.Second {
+			t.Errorf("v2.%d: got rating %d, plays %d, duration %v, want %d, 300, 1s", tc.version, ai.rating, ai.plays, ai.duration, tc.wantRating)
+		}
+	}
+}
+
+func TestResolveProbeError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Artist/Title/01 Track1.flac": &fstest.MapFile{Data: []byte("fLaC")},
+	}
+	d, err := NewDirectoryAlbumProducer(fsys, []string{"."}, Options{Probe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := d.Resolve("Artist/Title/01 Track1.flac")
+	if err == nil {
+		t.Errorf("Resolve of a truncated file: got no error")
+	}
+	if a == nil || a.Name != "Title" {
+		t.Errorf("Resolve of a truncated file: got %+v, want the album anyway", a)
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("d.Err(): got %v, want the error from Resolve instead", err)
+	}
+}
@@ -0,0 +1,197 @@
+package media
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SmartPlaylist is a playlist defined by rules on tracks' ratings, play
+// counts and dates, rather than by listing them.
+type SmartPlaylist struct {
+	Name  string
+	Rules []Rule
+	// By orders the tracks, highest first, by a field; otherwise they
+	// come in library order.
+	By string
+	// Limit, if non-zero, keeps only the first so many tracks.
+	Limit int
+}
+
+// Rule is a single condition on a field of a track: "rating" (stars out
+// of 5), "plays", "year" (of the album) or "added" (the year the track
+// was).
+type Rule struct {
+	Field string
+	// Op is one of "<", "<=", "=", "!=", ">=" and ">", comparing with
+	// Min, or "range", for Min to Max inclusive.
+	Op       string
+	Min, Max int
+}
+
+var ruleFields = []string{"rating", "plays", "year", "added"}
+
+// ParseSmartPlaylists reads smart playlist definitions, one per line:
+//
+//	Top rated = rating >= 4
+//	Most played = plays > 20, by plays, limit 100
+//	Nineties favourites = year 1990-1999, rating >= 4
+//
+// All of a playlist's rules must hold for a track to be on it. Blank
+// lines and lines starting with "#" are ignored.
+func ParseSmartPlaylists(r io.Reader) ([]*SmartPlaylist, error) {
+	sps := make([]*SmartPlaylist, 0)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		sp, err := parseSmartPlaylist(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		sps = append(sps, sp)
+	}
+	return sps, sc.Err()
+}
+
+func parseSmartPlaylist(text string) (*SmartPlaylist, error) {
+	name, def, ok := strings.Cut(text, "=")
+	sp := &SmartPlaylist{Name: strings.TrimSpace(name)}
+	if !ok || sp.Name == "" {
+		return nil, fmt.Errorf("%q: want <name> = <rules>", text)
+	}
+	for _, clause := range strings.Split(def, ",") {
+		words := strings.Fields(clause)
+		if len(words) == 0 {
+			return nil, fmt.Errorf("%q: empty rule", text)
+		}
+		switch {
+		case words[0] == "by" && len(words) == 2:
+			sp.By = words[1]
+			if !slices.Contains(ruleFields, sp.By) {
+				return nil, fmt.Errorf("%q: unknown field %q", text, sp.By)
+			}
+		case words[0] == "limit" && len(words) == 2:
+			n, err := strconv.Atoi(words[1])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%q: bad limit %q", text, words[1])
+			}
+			sp.Limit = n
+		default:
+			r, err := parseRule(words)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", text, err)
+			}
+			sp.Rules = append(sp.Rules, r)
+		}
+	}
+	if len(sp.Rules) == 0 {
+		return nil, fmt.Errorf("%q: no rules", text)
+	}
+	return sp, nil
+}
+
+func parseRule(words []string) (Rule, error) {
+	r := Rule{Field: words[0]}
+	if !slices.Contains(ruleFields, r.Field) {
+		return r, fmt.Errorf("unknown field %q", r.Field)
+	}
+	var err error
+	switch len(words) {
+	case 2:
+		// year 1990-1999
+		lo, hi, ok := strings.Cut(words[1], "-")
+		if !ok {
+			return r, fmt.Errorf("want %s <op> <n> or %s <n>-<n>", r.Field, r.Field)
+		}
+		r.Op = "range"
+		if r.Min, err = strconv.Atoi(lo); err == nil {
+			r.Max, err = strconv.Atoi(hi)
+		}
+	case 3:
+		r.Op = words[1]
+		switch r.Op {
+		case "<", "<=", "=", "!=", ">=", ">":
+		default:
+			return r, fmt.Errorf("unknown operator %q", r.Op)
+		}
+		r.Min, err = strconv.Atoi(words[2])
+	default:
+		return r, fmt.Errorf("want %s <op> <n> or %s <n>-<n>", r.Field, r.Field)
+	}
+	return r, err
+}
+
+// value returns the field f of t, on album a.
+func value(f string, a *AlbumMetadata, t Track) int {
+	switch f {
+	case "rating":
+		return (t.Rating + 10) / 20
+	case "plays":
+		return t.Plays
+	case "year":
+		return a.Year
+	case "added":
+		if t.Added.IsZero() {
+			return 0
+		}
+		return t.Added.Year()
+	}
+	return 0
+}
+
+func (r Rule) holds(a *AlbumMetadata, t Track) bool {
+	v := value(r.Field, a, t)
+	switch r.Op {
+	case "<":
+		return v < r.Min
+	case "<=":
+		return v <= r.Min
+	case "=":
+		return v == r.Min
+	case "!=":
+		return v != r.Min
+	case ">=":
+		return v >= r.Min
+	case ">":
+		return v > r.Min
+	case "range":
+		return v >= r.Min && v <= r.Max
+	}
+	return false
+}
+
+// Select returns the tracks of albums on the playlist, each as its album
+// with just that track, as Resolve does.
+func (sp *SmartPlaylist) Select(albums []*AlbumMetadata) []*AlbumMetadata {
+	sel := make([]*AlbumMetadata, 0)
+	for _, a := range albums {
+	tracks:
+		for _, t := range a.Tracks {
+			for _, r := range sp.Rules {
+				if !r.holds(a, t) {
+					continue tracks
+				}
+			}
+			one := *a
+			one.Tracks = []Track{t}
+			one.Duration = t.Duration
+			sel = append(sel, &one)
+		}
+	}
+	if sp.By != "" {
+		sort.SliceStable(sel, func(i, j int) bool {
+			return value(sp.By, sel[i], sel[i].Tracks[0]) > value(sp.By, sel[j], sel[j].Tracks[0])
+		})
+	}
+	if sp.Limit > 0 && len(sel) > sp.Limit {
+		sel = sel[:sp.Limit]
+	}
+	return sel
+}
@@ -0,0 +1,84 @@
+package media
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSmartPlaylists(t *testing.T) {
+	defs := `# Favourites
+Top rated = rating >= 4
+
+Most played = plays > 20, by plays, limit 2
+Nineties = year 1990-1999, rating >= 3
+`
+	got, err := ParseSmartPlaylists(strings.NewReader(defs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*SmartPlaylist{
+		{Name: "Top rated", Rules: []Rule{{Field: "rating", Op: ">=", Min: 4}}},
+		{Name: "Most played", Rules: []Rule{{Field: "plays", Op: ">", Min: 20}}, By: "plays", Limit: 2},
+		{Name: "Nineties", Rules: []Rule{{Field: "year", Op: "range", Min: 1990, Max: 1999}, {Field: "rating", Op: ">=", Min: 3}}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseSmartPlaylists mismatch (-want +got):\n%s", diff)
+	}
+	for _, bad := range []string{
+		"No rules",
+		"= rating > 4",
+		"Bad field = mood > 4",
+		"Bad op = rating ~ 4",
+		"Bad value = rating > four",
+		"Bad range = year 1990",
+		"Bad limit = rating > 4, limit none",
+		"Bad order = rating > 4, by mood",
+		"Only limit = limit 4",
+	} {
+		if _, err := ParseSmartPlaylists(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseSmartPlaylists(%q): got nil err", bad)
+		}
+	}
+}
+
+func TestSmartPlaylistSelect(t *testing.T) {
+	added := func(year int) time.Time {
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	albums := []*AlbumMetadata{
+		{Artist: "Artist", Name: "Old", Year: 1994, Tracks: []Track{
+			{Title: "One", Rating: 100, Plays: 30, Added: added(2005)},
+			{Title: "Two", Rating: 60, Plays: 50, Added: added(2005)},
+			{Title: "Three", Plays: 2},
+		}},
+		{Artist: "Artist", Name: "New", Year: 2011, Tracks: []Track{
+			{Title: "Four", Rating: 80, Plays: 40, Added: added(2012)},
+		}},
+	}
+	cases := []struct {
+		def  string
+		want []string
+	}{
+		{def: "Top = rating >= 4", want: []string{"One", "Four"}},
+		{def: "Most = plays > 20, by plays, limit 2", want: []string{"Two", "Four"}},
+		{def: "Nineties = year 1990-1999, rating >= 3", want: []string{"One", "Two"}},
+		{def: "Recent = added 2010-2019", want: []string{"Four"}},
+		{def: "Unrated = rating = 0", want: []string{"Three"}},
+	}
+	for _, tc := range cases {
+		sps, err := ParseSmartPlaylists(strings.NewReader(tc.def))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0)
+		for _, a := range sps[0].Select(albums) {
+			got = append(got, a.Tracks[0].Title)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.def, diff)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package media
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultTrackPatterns are tried in order against each filename, less
+	// its extension, until one matches. The named groups "disc",
+	// "track", "artist" and "title" are used where present.
+	DefaultTrackPatterns = []string{
+		// "1-03 Artist - Title", "1-03 Title"
+		`^(?P<disc>[0-9]{1,2})-(?P<track>[0-9]{2,3})[ ._-]+(?P<artist>.+?) - (?P<title>.+)$`,
+		`^(?P<disc>[0-9]{1,2})-(?P<track>[0-9]{2,3})[ ._-]+(?P<title>.+)$`,
+		// "A1 Title", as on vinyl rips.
+		`^[A-H](?P<track>[0-9]{1,2})[ ._-]+(?P<title>.+)$`,
+		// "03 Artist - Title", "03. Title", "03 - Title"
+		`^(?P<track>[0-9]{1,3})\.?\s+(?P<artist>.+?) - (?P<title>.+)$`,
+		`^(?P<track>[0-9]{1,3})(?:\.|\s*-)?\s+(?P<title>.+)$`,
+		// "03_title", "03.title", though not "10_000 Maniacs".
+		`^(?P<track>[0-9]{1,3})[._-](?P<title>[^0-9].*)$`,
+		`^(?P<title>.+)$`,
+	}
+
+	yearMatcher = regexp.MustCompile("(?:^|[\\s(\\[])(?P<year>(?:19|20)[0-9]{2})(?:$|[\\s)\\]])")
+	// placeholderMatcher matches the names rippers give tracks they
+	// couldn't look up, e.g. "Track 07" or "Track07".
+	placeholderMatcher = regexp.MustCompile("^(?i)(?:audio ?)?(?:track|piste|pista|titel|traccia|trilha)(?:[ _-]+[0-9]+|[0-9]{2,})$")
+	spaceMatcher       = regexp.MustCompile("\\s+")
+
+	imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp"}
+)
+
+// Track is a single audio file of an album.
+type Track struct {
+	// Number and Disc are 0 if unknown.
+	Number int
+	Disc   int
+	Title  string
+	// Artist is only known for filenames or libraries which name it,
+	// and then only if it isn't just the album's artist.
+	Artist string
+	// Placeholder is set for titles like "Track 07", which say nothing
+	// about the track and shouldn't be matched against.
+	Placeholder bool
+	// Filename is relative to the album's directory.
+	Filename string
+	// Format is the lower-cased file extension, e.g. "mp3".
+	Format string
+	// Duration and Bitrate (in kbit/s) are 0 if unknown.
+	Duration time.Duration
+	Bitrate  int
+	// Rating (0-100, as iTunes has it), Plays and Added are only known
+	// for tracks read from a Library; Rating is 0 if unrated.
+	Rating int
+	Plays  int
+	Added  time.Time
+}
+
+// trackParser derives what it can of a Track from its filename.
+type trackParser struct {
+	patterns []*regexp.Regexp
+}
+
+func newTrackParser(patterns []string) (*trackParser, error) {
+	p := &trackParser{}
+	for _, pat := range patterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, err
+		}
+		if re.SubexpIndex("title") < 0 {
+			return nil, fmt.Errorf("track pattern %q: missing title group", pat)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return p, nil
+}
+
+func (p *trackParser) parse(filename string) Track {
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	t := Track{
+		Title:    base,
+		Filename: filename,
+		Format:   strings.TrimPrefix(strings.ToLower(ext), "."),
+	}
+	for _, re := range p.patterns {
+		m := re.FindStringSubmatch(base)
+		if m == nil {
+			continue
+		}
+		group := func(name string) string {
+			if i := re.SubexpIndex(name); i >= 0 {
+				return m[i]
+			}
+			return ""
+		}
+		t.Title = normalizeTitle(group("title"))
+		t.Artist = normalizeTitle(group("artist"))
+		t.Number, _ = strconv.Atoi(group("track"))
+		t.Disc, _ = strconv.Atoi(group("disc"))
+		break
+	}
+	t.Placeholder = placeholderMatcher.MatchString(t.Title)
+	return t
+}
+
+// normalizeTitle turns underscores into spaces, and dots too if they're
+// all that separates the words, as in "Some.Title".
+func normalizeTitle(s string) string {
+	s = strings.ReplaceAll(s, "_", " ")
+	if !strings.Contains(s, " ") {
+		s = strings.ReplaceAll(s, ".", " ")
+	}
+	return strings.TrimSpace(spaceMatcher.ReplaceAllString(s, " "))
+}
+
+// attribute drops track artists which are just the album's artist, and
+// for single-artist albums, folds any other back into the title since
+// it's more likely part of it ("Title - Live") than a guest.
+func (t *Track) attribute(albumArtist string) {
+	if t.Artist == "" {
+		return
+	}
+	if strings.EqualFold(t.Artist, albumArtist) {
+		t.Artist = ""
+		return
+	}
+	if albumArtist != VariousArtists {
+		t.Title = t.Artist + " - " + t.Title
+		t.Artist = ""
+	}
+}
+
+// parseYear finds a plausible release year in an album's name, as in
+// "1979 - Live" or "Live (1979)", returning 0 if there isn't one.
+func parseYear(name string) int {
+	matches := yearMatcher.FindStringSubmatch(name)
+	if matches == nil {
+		return 0
+	}
+	y, _ := strconv.Atoi(matches[yearMatcher.SubexpIndex("year")])
+	return y
+}
+
+func isImageFile(filename string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(path.Ext(filename)))
+}
+
+// Titles returns the titles of the album's tracks, in order, leaving out
+// placeholders.
+func (a *AlbumMetadata) Titles() []string {
+	titles := make([]string, 0, len(a.Tracks))
+	for _, t := range a.Tracks {
+		if !t.Placeholder {
+			titles = append(titles, t.Title)
+		}
+	}
+	return titles
+}
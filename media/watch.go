@@ -0,0 +1,196 @@
+package media
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultSettle is how long an album directory must go without changes
+// before a Watcher considers it complete. Rippers tend to write a track at
+// a time, so this needs to comfortably exceed the time to rip one track.
+const DefaultSettle = 2 * time.Minute
+
+// Watcher watches local library roots for albums being added, yielding
+// each once its directory has been quiet for the settle period.
+// Subdirectories are watched as they appear. Albums are filtered through
+// the Index, if any, exactly as they are by a directoryAlbumProducer.
+type Watcher struct {
+	p       *directoryAlbumProducer
+	roots   []string
+	w       *fsnotify.Watcher
+	l       *log.Logger
+	settle  time.Duration
+	ready   chan string
+	done    chan struct{}
+	pending map[string]*time.Timer
+}
+
+func (w *Watcher) Albums() AlbumIterFn {
+	return w.p.Albums()
+}
+
+// Synced records alb in the Index, as for a directoryAlbumProducer.
+func (w *Watcher) Synced(alb *AlbumMetadata) {
+	w.p.Synced(alb)
+}
+
+// Start watches until ctx is done, at which point the channel is closed.
+func (w *Watcher) Start(ctx context.Context) {
+	for _, root := range w.roots {
+		w.addTree(root)
+	}
+	defer func() {
+		close(w.done)
+		for _, t := range w.pending {
+			t.Stop()
+		}
+		w.w.Close()
+		w.p.wg.Wait()
+		close(w.p.ch)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev)
+		case err, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+			w.l.Println("warn:", err)
+		case dir := <-w.ready:
+			delete(w.pending, dir)
+			w.scan(ctx, dir)
+		}
+	}
+}
+
+// addTree watches dir and every directory beneath it.
+func (w *Watcher) addTree(dir string) {
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			w.l.Println("warn:", err)
+			return nil
+		}
+		if !e.IsDir() {
+			return nil
+		}
+		if err := w.w.Add(path); err != nil {
+			w.l.Println("warn:", err)
+		}
+		return nil
+	})
+	if err != nil {
+		w.l.Println("warn:", err)
+	}
+}
+
+func (w *Watcher) handle(ev fsnotify.Event) {
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+		return
+	}
+	if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+		// Whole trees are often moved into place at once, and
+		// there'll be no events for what's already inside them.
+		w.addTree(ev.Name)
+		w.touch(ev.Name)
+		return
+	}
+	if isAudioFile(ev.Name) {
+		w.touch(filepath.Dir(ev.Name))
+	}
+}
+
+// touch (re)starts the settle timer for the album directory containing
+// dir. Pending directories nested in one another are coalesced into the
+// outermost, since scanning it covers the rest.
+func (w *Watcher) touch(dir string) {
+	if _, ok := discNumber(filepath.Base(dir)); ok {
+		dir = filepath.Dir(dir)
+	}
+	for p, t := range w.pending {
+		if underAny(dir, []string{p}) {
+			t.Reset(w.settle)
+			return
+		}
+		if underAny(p, []string{dir}) {
+			t.Stop()
+			delete(w.pending, p)
+		}
+	}
+	w.pending[dir] = time.AfterFunc(w.settle, func() {
+		select {
+		case w.ready <- dir:
+		case <-w.done:
+		}
+	})
+}
+
+func (w *Watcher) scan(ctx context.Context, dir string) {
+	for i, root := range w.roots {
+		if !underAny(dir, []string{root}) {
+			continue
+		}
+		rel := make([]string, 0)
+		if dir != root {
+			rel = strings.Split(strings.TrimPrefix(dir, root+"/"), "/")
+		}
+		w.p.wg.Add(1)
+		go w.p.walk(ctx, w.p.roots[i], rel)
+		return
+	}
+}
+
+// NewWatcher creates a Watcher for the given directories on the local
+// filesystem. Start() must be called to start watching. Errors are logged
+// rather than returned, since a Watcher runs indefinitely.
+func NewWatcher(dirs []string, o Options) (*Watcher, error) {
+	roots := make([]string, len(dirs))
+	fsRoots := make([]string, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		if roots[i], err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+		if fsRoots[i], err = LocalRoot(roots[i]); err != nil {
+			return nil, err
+		}
+	}
+	p, err := NewDirectoryAlbumProducer(os.DirFS("/"), fsRoots, o)
+	if err != nil {
+		return nil, err
+	}
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	settle := o.Settle
+	if settle <= 0 {
+		settle = DefaultSettle
+	}
+	w := &Watcher{
+		p:       p,
+		roots:   roots,
+		w:       fw,
+		l:       log.New(os.Stderr, "watch: ", log.Ldate|log.Ltime|log.Lshortfile),
+		settle:  settle,
+		ready:   make(chan string),
+		done:    make(chan struct{}),
+		pending: make(map[string]*time.Timer),
+	}
+	p.onFail = func(err error) {
+		w.l.Println("warn:", err)
+	}
+	return w, nil
+}
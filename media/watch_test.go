@@ -0,0 +1,76 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatcher(t *testing.T) {
+	tmp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmp, "Artist1", "Title1"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher([]string{tmp}, Options{Settle: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		w.Start(ctx)
+	}()
+	// Give the watches time to be established.
+	time.Sleep(100 * time.Millisecond)
+	files := []string{
+		"Artist1/Title1/01 Track1.mp3",
+		"Artist2/Title2/CD1/01 Track2.mp3",
+		"Artist2/Title2/CD2/01 Track3.mp3",
+	}
+	for _, f := range files {
+		p := filepath.Join(tmp, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("ID3"), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []AlbumMetadata{
+		AlbumMetadata{
+			Artist: "Artist1",
+			Name:   "Title1",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "Track1", Filename: "01 Track1.mp3", Format: "mp3"},
+			},
+		},
+		AlbumMetadata{
+			Artist: "Artist2",
+			Name:   "Title2",
+			Tracks: []Track{
+				{Number: 1, Disc: 1, Title: "Track2", Filename: "CD1/01 Track2.mp3", Format: "mp3"},
+				{Number: 1, Disc: 2, Title: "Track3", Filename: "CD2/01 Track3.mp3", Format: "mp3"},
+			},
+		},
+	}
+	got := make([]AlbumMetadata, 0)
+	for a := range w.Albums() {
+		got = append(got, *a)
+		if len(got) == len(want) {
+			break
+		}
+	}
+	for i := range want {
+		if want[i].Path, err = LocalRoot(filepath.Join(tmp, want[i].Artist, want[i].Name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sortAlbums(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Album mismatch (-want +got):\n%s", diff)
+	}
+}
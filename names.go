@@ -0,0 +1,131 @@
+package spotsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SCOPE_ARTIST rules apply to artist names.
+	SCOPE_ARTIST = "artist"
+	// SCOPE_TITLE rules apply to album and track titles.
+	SCOPE_TITLE = "title"
+	// SCOPE_ANY rules apply to both.
+	SCOPE_ANY = "any"
+)
+
+// DefaultNameRules are the rules NewNameRules uses if given none, in the
+// format ParseNameRules reads.
+var DefaultNameRules = []string{
+	// "(Remastered 2011)", "[Deluxe Edition]", "(2009 Remaster)"
+	`title \s*[(\[][^)\]]*\b(?:remaster(?:ed)?|deluxe|expanded|anniversary|bonus tracks?|special edition|collector'?s edition|legacy edition)\b[^)\]]*[)\]]`,
+	// Spotify's "Title - Remastered 2011", "Title - 2009 Remaster"
+	`title \s+-\s+(?:[0-9]{4}\s+)?remaster(?:ed)?\b.*$`,
+	// "Disc 1", "(CD 2)", "- Disk 1"
+	`title \s*[-,:]?\s*[(\[]?\b(?:disc|disk|cd)\s*[0-9]+[)\]]?\s*$`,
+	`title \s+-\s+(?:EP|Single)$`,
+	// "Song (feat. Someone)", "Artist ft. Someone"
+	`any \s*[(\[](?:feat\.?|ft\.?|featuring)\s[^)\]]*[)\]]`,
+	`any \s+(?:feat\.?|ft\.|featuring)\s.*$`,
+	`any ^(?:the|die|der|das|les|le|la|los|las|el|il)\s+`,
+	// "&" and "+" for "and", which survives canonicalization.
+	`any (\s)(?:&|\+)(\s) => ${1}and${2}`,
+}
+
+// nameRule replaces matches of pattern in names within scope.
+type nameRule struct {
+	scope   string
+	pattern *regexp.Regexp
+	repl    string
+}
+
+// NameRules tidies names of the decorations which differ between a local
+// library and Spotify, before they're canonicalized and compared. A nil
+// *NameRules applies DefaultNameRules.
+type NameRules struct {
+	rules []nameRule
+}
+
+var defaultNameRules = func() *NameRules {
+	nr, err := NewNameRules(DefaultNameRules)
+	if err != nil {
+		panic(err)
+	}
+	return nr
+}()
+
+// NewNameRules compiles rules, in the format ParseNameRules reads, or
+// DefaultNameRules if rules is empty.
+func NewNameRules(rules []string) (*NameRules, error) {
+	if len(rules) == 0 {
+		rules = DefaultNameRules
+	}
+	return ParseNameRules(strings.NewReader(strings.Join(rules, "\n")))
+}
+
+// ParseNameRules reads name rules, one per line:
+//
+//	<scope> <regexp>
+//	<scope> <regexp> => <replacement>
+//
+// where scope is SCOPE_ARTIST, SCOPE_TITLE or SCOPE_ANY. Matches are
+// removed, or replaced, with $1 and the like expanded; matching ignores
+// case. Rules apply in order. Blank lines and lines starting with "#" are
+// ignored.
+func ParseNameRules(r io.Reader) (*NameRules, error) {
+	nr := &NameRules{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		scope, rest, _ := strings.Cut(text, " ")
+		switch scope {
+		case SCOPE_ARTIST, SCOPE_TITLE, SCOPE_ANY:
+		default:
+			return nil, fmt.Errorf("line %d: unknown scope %q", line, scope)
+		}
+		expr, repl, _ := strings.Cut(rest, " => ")
+		if strings.TrimSpace(expr) == "" {
+			return nil, fmt.Errorf("line %d: want <scope> <regexp> [=> <replacement>]", line)
+		}
+		p, err := regexp.Compile("(?i)" + strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		nr.rules = append(nr.rules, nameRule{scope: scope, pattern: p, repl: strings.TrimSpace(repl)})
+	}
+	return nr, sc.Err()
+}
+
+func (nr *NameRules) apply(scope string, name string) string {
+	if nr == nil {
+		nr = defaultNameRules
+	}
+	orig := name
+	for _, r := range nr.rules {
+		if r.scope == scope || r.scope == SCOPE_ANY {
+			name = r.pattern.ReplaceAllString(name, r.repl)
+		}
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		// Nothing but decoration, e.g. an album called "Deluxe".
+		return orig
+	}
+	return name
+}
+
+// Artist applies the rules for artists to name.
+func (nr *NameRules) Artist(name string) string {
+	return nr.apply(SCOPE_ARTIST, name)
+}
+
+// Title applies the rules for titles to name.
+func (nr *NameRules) Title(name string) string {
+	return nr.apply(SCOPE_TITLE, name)
+}
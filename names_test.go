@@ -0,0 +1,72 @@
+package spotsync_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tschroed/spotsync"
+)
+
+func TestDefaultNameRules(t *testing.T) {
+	var nr *spotsync.NameRules
+	cases := []struct {
+		artist bool
+		input  string
+		want   string
+	}{
+		{input: "Abbey Road (Remastered 2009)", want: "Abbey Road"},
+		{input: "Abbey Road [Super Deluxe Edition]", want: "Abbey Road"},
+		{input: "Here Comes the Sun - Remastered 2009", want: "Here Comes the Sun"},
+		{input: "Something - 2019 Remaster", want: "Something"},
+		{input: "Sandinista! Disc 1", want: "Sandinista!"},
+		{input: "Sandinista! (CD 2)", want: "Sandinista!"},
+		{input: "Lovesick - EP", want: "Lovesick"},
+		{input: "Empire State of Mind (feat. Alicia Keys)", want: "Empire State of Mind"},
+		{input: "The Wall", want: "Wall"},
+		{input: "Deluxe", want: "Deluxe"},
+		{input: "Live! - (CD1_ Boston 1979)", want: "Live! - (CD1_ Boston 1979)"},
+		{artist: true, input: "The Beatles", want: "Beatles"},
+		{artist: true, input: "Die Toten Hosen", want: "Toten Hosen"},
+		{artist: true, input: "Los Lobos", want: "Lobos"},
+		{artist: true, input: "Simon & Garfunkel", want: "Simon and Garfunkel"},
+		{artist: true, input: "Jay-Z ft. Alicia Keys", want: "Jay-Z"},
+		{artist: true, input: "Daft Punk", want: "Daft Punk"},
+		{artist: true, input: "Deluxe", want: "Deluxe"},
+	}
+	for _, tc := range cases {
+		got := nr.Title(tc.input)
+		if tc.artist {
+			got = nr.Artist(tc.input)
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseNameRules(t *testing.T) {
+	nr, err := spotsync.ParseNameRules(strings.NewReader(`
+# Drop live annotations, and spell out "vs."
+title \s*\(live\)
+artist \bvs\.? => versus
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nr.Title("Alive (Live)"), "Alive"; got != want {
+		t.Errorf("Title: got %q, want %q", got, want)
+	}
+	if got, want := nr.Artist("Armand vs. Moby"), "Armand versus Moby"; got != want {
+		t.Errorf("Artist: got %q, want %q", got, want)
+	}
+	// Only the given rules apply.
+	if got, want := nr.Artist("The Beatles"), "The Beatles"; got != want {
+		t.Errorf("Artist: got %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"album x", "title (", "title"} {
+		if _, err := spotsync.ParseNameRules(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseNameRules(%q): got no error", bad)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+)
+
+// aliases are the artist aliases from the cache, under each of artistKeys
+// of the local artist.
+var aliases map[string]*cache.Alias
+
+// artistIDMatcher matches Spotify artist URIs and bare IDs.
+var artistIDMatcher = regexp.MustCompile("^(?:spotify:artist:)?([0-9A-Za-z]{22})$")
+
+// readAliases reads artist aliases, one artist per line:
+//
+//	Prince = The Artist Formerly Known As Prince, spotify:artist:5a2EaR3hamoenG9rDuVn8j
+//
+// giving the local artist's name and then what Spotify calls them: names,
+// or artist IDs or URIs. Blank lines and lines starting with "#" are
+// ignored.
+func readAliases(r io.Reader) ([]*cache.Alias, error) {
+	as := make([]*cache.Alias, 0)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		artist, rest, ok := strings.Cut(text, "=")
+		a := &cache.Alias{Artist: strings.TrimSpace(artist)}
+		if !ok || a.Artist == "" {
+			return nil, fmt.Errorf("line %d: want <artist> = <name or ID>, ...", line)
+		}
+		for _, v := range strings.Split(rest, ",") {
+			v = strings.TrimSpace(v)
+			if m := artistIDMatcher.FindStringSubmatch(v); m != nil {
+				a.IDs = append(a.IDs, spotify.ID(m[1]))
+			} else if v != "" {
+				a.Names = append(a.Names, v)
+			}
+		}
+		if len(a.Names) == 0 && len(a.IDs) == 0 {
+			return nil, fmt.Errorf("line %d: no aliases for %s", line, a.Artist)
+		}
+		as = append(as, a)
+	}
+	return as, sc.Err()
+}
+
+// importAliases stores the aliases in files in c, replacing any for the
+// same artists, or with no files lists those stored.
+func importAliases(c *cache.Cache, files []string) error {
+	if len(files) == 0 {
+		as, err := c.Aliases()
+		if err != nil {
+			return err
+		}
+		for _, a := range as {
+			fmt.Println(a.Artist, "=", strings.Join(aliasValues(a), ", "))
+		}
+		return nil
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		as, err := readAliases(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, a := range as {
+			if err := c.UpsertAlias(a); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Imported", len(as), "aliases from", file)
+	}
+	return nil
+}
+
+// unalias forgets the aliases of artists.
+func unalias(c *cache.Cache, artists []string) error {
+	for _, artist := range artists {
+		if err := c.DeleteAlias(&cache.Alias{Artist: artist}); err != nil {
+			return err
+		}
+		fmt.Println("Removed aliases of", artist)
+	}
+	return nil
+}
+
+func aliasValues(a *cache.Alias) []string {
+	vs := append([]string{}, a.Names...)
+	for _, id := range a.IDs {
+		vs = append(vs, "spotify:artist:"+string(id))
+	}
+	return vs
+}
+
+// loadAliases reads the aliases in c, keyed for aliased. It's done once
+// the name rules are known, since keys depend on them.
+func loadAliases(c *cache.Cache) (map[string]*cache.Alias, error) {
+	as, err := c.Aliases()
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*cache.Alias)
+	for _, a := range as {
+		for _, k := range artistKeys(a.Artist) {
+			byKey[k] = a
+		}
+	}
+	return byKey, nil
+}
+
+// aliased reports whether ar is one of the aliases of the local artist
+// with keys art.
+func aliased(art []string, ar spotify.SimpleArtist) bool {
+	for _, k := range art {
+		a := aliases[k]
+		if a == nil {
+			continue
+		}
+		if ar.ID != "" && slices.Contains(a.IDs, ar.ID) {
+			return true
+		}
+		for _, n := range a.Names {
+			if anyKey(artistKeys(n), artistKeys(ar.Name), equal) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+)
+
+func TestReadAliases(t *testing.T) {
+	got, err := readAliases(strings.NewReader(`
+# Renamed, and spelt differently.
+Prince = The Artist Formerly Known As Prince, spotify:artist:5a2EaR3hamoenG9rDuVn8j
+Beyonce = Beyoncé
+Sunn O))) = 0Ag2nbvGmEAPsxv5tSBDFK
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*cache.Alias{
+		{Artist: "Prince", Names: []string{"The Artist Formerly Known As Prince"}, IDs: []spotify.ID{"5a2EaR3hamoenG9rDuVn8j"}},
+		{Artist: "Beyonce", Names: []string{"Beyoncé"}},
+		{Artist: "Sunn O)))", IDs: []spotify.ID{"0Ag2nbvGmEAPsxv5tSBDFK"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readAliases -want, +got: %s", diff)
+	}
+	for _, bad := range []string{"Prince", "= Prince", "Prince = , "} {
+		if _, err := readAliases(strings.NewReader(bad)); err == nil {
+			t.Errorf("readAliases(%q): got no error", bad)
+		}
+	}
+}
+
+func TestBestMatchesAliased(t *testing.T) {
+	albums := []spotify.SimpleAlbum{
+		{ID: "1", Name: "Emancipation", Artists: []spotify.SimpleArtist{{ID: "5a2EaR3hamoenG9rDuVn8j", Name: "The Artist"}}},
+		{ID: "2", Name: "Chaos and Disorder", Artists: []spotify.SimpleArtist{{Name: "The Artist Formerly Known As Prince"}}},
+	}
+	aliases = map[string]*cache.Alias{
+		"prince": {Artist: "Prince", Names: []string{"The Artist Formerly Known As Prince"}, IDs: []spotify.ID{"5a2EaR3hamoenG9rDuVn8j"}},
+	}
+	defer func() { aliases = nil }()
+	for _, al := range albums {
+		if ms, match := bestMatches("Prince", al.Name, albums); len(ms) != 1 || ms[0].ID != al.ID || match != MATCH_EXACT {
+			t.Errorf("bestMatches(Prince, %q): got %v, %d, want album %s, %d", al.Name, ms, match, al.ID, MATCH_EXACT)
+		}
+	}
+	if ms, _ := bestMatches("Someone", "Emancipation", albums); len(ms) != 0 {
+		t.Errorf("bestMatches(Someone, Emancipation): got %v, want none", ms)
+	}
+}
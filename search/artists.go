@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+// discographyAge is how long a pinned artist's discography is trusted
+// before being fetched again, for new releases.
+const discographyAge = 7 * 24 * time.Hour
+
+// allAlbumTypes asks for an artist's whole discography.
+var allAlbumTypes = []spotify.AlbumType{
+	spotify.AlbumTypeAlbum,
+	spotify.AlbumTypeSingle,
+	spotify.AlbumTypeAppearsOn,
+	spotify.AlbumTypeCompilation,
+}
+
+// pinnedArtist returns which of al's artists is the local artist, or nil
+// if none is.
+func pinnedArtist(artist string, al spotify.SimpleAlbum) *spotify.SimpleArtist {
+	if artist == media.VariousArtists {
+		return nil
+	}
+	art := artistKeys(artist)
+	for _, ar := range al.Artists {
+		if ar.ID != "" && (anyKey(artistKeys(ar.Name), art, equal) || aliased(art, ar)) {
+			return &ar
+		}
+	}
+	return nil
+}
+
+// pinArtist remembers the Spotify artist of al, an exact match for one of
+// artist's albums, unless they already have one.
+func (s *syncer) pinArtist(artist string, al spotify.SimpleAlbum) {
+	if _, err := s.c.ArtistPin(artist); err == nil {
+		return
+	}
+	ar := pinnedArtist(artist, al)
+	if ar == nil {
+		return
+	}
+	log.Printf("[info] Pinning %s to Spotify artist %s\n", artist, ar.ID)
+	if err := s.c.UpsertArtistPin(&cache.ArtistPin{Artist: artist, ID: ar.ID, Name: ar.Name}); err != nil {
+		log.Println("[warn] Failed to pin artist:", err)
+	}
+}
+
+// listPins prints the artist pins in c.
+func listPins(c *cache.Cache) error {
+	ps, err := c.ArtistPins()
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		fmt.Println(p.Artist, "=", p.Name, "spotify:artist:"+string(p.ID))
+	}
+	return nil
+}
+
+// unpin forgets the Spotify artists of artists, so they're matched afresh
+// by their next exact match.
+func unpin(c *cache.Cache, artists []string) error {
+	for _, artist := range artists {
+		if err := c.DeleteArtistPin(&cache.ArtistPin{Artist: artist}); err != nil {
+			return err
+		}
+		fmt.Println("Unpinned", artist)
+	}
+	return nil
+}
+
+// fromDiscography returns the exact matches for alb among its artist's
+// pinned discography, if they have one.
+func (s *syncer) fromDiscography(alb *media.AlbumMetadata, artName string, albName string) []spotify.SimpleAlbum {
+	pin, err := s.c.ArtistPin(alb.Artist)
+	if err != nil {
+		return nil
+	}
+	albums, err := s.discography(pin)
+	if err != nil {
+		log.Println("[warn] Failed to get discography:", err)
+		return nil
+	}
+	ms, match := s.matchAlbum(alb, artName, albName, albums)
+	if match != MATCH_EXACT {
+		return nil
+	}
+	fmt.Println(">> Found", albName, "in the discography of", pin.Name)
+	return ms
+}
+
+// discography returns pin's albums, fetching them if they're not known or
+// are out of date.
+func (s *syncer) discography(pin *cache.ArtistPin) ([]spotify.SimpleAlbum, error) {
+	if pin.Albums != nil && time.Since(pin.Fetched) < discographyAge {
+		return pin.Albums, nil
+	}
+	log.Println("[info] Fetching discography of", pin.Name)
+	page, err := s.client.GetArtistAlbums(s.ctx, pin.ID, allAlbumTypes, spotify.Limit(50))
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]spotify.SimpleAlbum, 0)
+	for {
+		albums = append(albums, page.Albums...)
+		err := s.client.NextPage(s.ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Albums released in several markets can be listed more than once.
+	albums = mergeAlbums(albums)
+	pin.Albums, pin.Fetched = albums, time.Now()
+	if err := s.c.UpsertArtistPin(pin); err != nil {
+		log.Println("[warn] Failed to upsert discography into cache:", err)
+	}
+	return albums, nil
+}
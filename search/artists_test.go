@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestPinnedArtist(t *testing.T) {
+	al := spotify.SimpleAlbum{Name: "Album", Artists: []spotify.SimpleArtist{
+		{ID: "guest1", Name: "Guest"},
+		{ID: "artist1", Name: "The Artist"},
+	}}
+	cases := []struct {
+		artist string
+		want   spotify.ID
+	}{
+		{artist: "Artist", want: "artist1"},
+		{artist: "Guest", want: "guest1"},
+		{artist: "Someone Else"},
+		{artist: media.VariousArtists},
+	}
+	for _, tc := range cases {
+		ar := pinnedArtist(tc.artist, al)
+		var got spotify.ID
+		if ar != nil {
+			got = ar.ID
+		}
+		if got != tc.want {
+			t.Errorf("pinnedArtist(%q): got %q, want %q", tc.artist, got, tc.want)
+		}
+	}
+}
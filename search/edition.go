@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	// EDITION_ORIGINAL prefers the earliest release.
+	EDITION_ORIGINAL = "original"
+	// EDITION_TRACKS prefers the edition with as many tracks as the
+	// local album, or nearest.
+	EDITION_TRACKS = "tracks"
+	// EDITION_DELUXE prefers deluxe and expanded editions, or failing
+	// that those with more tracks than the local album.
+	EDITION_DELUXE = "deluxe"
+	// EDITION_EXPLICIT and EDITION_CLEAN prefer editions with and without
+	// explicit tracks respectively.
+	EDITION_EXPLICIT = "explicit"
+	EDITION_CLEAN    = "clean"
+)
+
+var deluxeMatcher = regexp.MustCompile(`(?i)\b(?:deluxe|expanded|anniversary|bonus|special edition|collector'?s)\b`)
+
+// edition is what edition policies compare albums by.
+type edition struct {
+	al       spotify.SimpleAlbum
+	tracks   int
+	explicit bool
+	deluxe   bool
+}
+
+// editionCompare compares two editions of local for each preference,
+// returning -1 if a is preferred, 1 if b is and 0 if neither. Preferences
+// by track count have no say if local's is unknown.
+var editionCompare = map[string]func(local *media.AlbumMetadata, a, b *edition) int{
+	EDITION_ORIGINAL: func(local *media.AlbumMetadata, a, b *edition) int {
+		switch {
+		case a.al.ReleaseDate == b.al.ReleaseDate:
+			return 0
+		case b.al.ReleaseDate == "", a.al.ReleaseDate != "" && a.al.ReleaseDate < b.al.ReleaseDate:
+			return -1
+		}
+		return 1
+	},
+	EDITION_TRACKS: func(local *media.AlbumMetadata, a, b *edition) int {
+		n := len(local.Tracks)
+		if n == 0 {
+			return 0
+		}
+		return compareBool(abs(a.tracks-n) < abs(b.tracks-n), abs(b.tracks-n) < abs(a.tracks-n))
+	},
+	EDITION_DELUXE: func(local *media.AlbumMetadata, a, b *edition) int {
+		if c := compareBool(a.deluxe, b.deluxe); c != 0 {
+			return c
+		}
+		n := len(local.Tracks)
+		if n == 0 {
+			return 0
+		}
+		return compareBool(a.tracks > n && b.tracks <= n, b.tracks > n && a.tracks <= n)
+	},
+	EDITION_EXPLICIT: func(local *media.AlbumMetadata, a, b *edition) int {
+		return compareBool(a.explicit, b.explicit)
+	},
+	EDITION_CLEAN: func(local *media.AlbumMetadata, a, b *edition) int {
+		return compareBool(!a.explicit, !b.explicit)
+	},
+}
+
+// compareBool prefers whichever of a and b alone is true.
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case b && !a:
+		return 1
+	}
+	return 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// parseEditions checks a comma separated list of edition preferences, ""
+// for none.
+func parseEditions(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	es := strings.Split(list, ",")
+	for _, e := range es {
+		if editionCompare[e] == nil {
+			return nil, fmt.Errorf("unknown edition preference %q", e)
+		}
+	}
+	return es, nil
+}
+
+// byEdition orders albums, editions of alb which otherwise match equally
+// well, by s.editions, each preference breaking ties in those before it.
+// Albums still tied keep their order.
+func (s *syncer) byEdition(alb *media.AlbumMetadata, albums []spotify.SimpleAlbum) []spotify.SimpleAlbum {
+	if len(albums) < 2 || len(s.editions) == 0 {
+		return albums
+	}
+	eds := make([]*edition, len(albums))
+	for i, al := range albums {
+		fa, err := s.album(al.ID)
+		if err != nil {
+			log.Println("[warn] Failed to get album for edition check:", err)
+			return albums
+		}
+		eds[i] = &edition{
+			al:     al,
+			tracks: int(fa.Tracks.Total),
+			deluxe: deluxeMatcher.MatchString(al.Name),
+		}
+		for _, t := range fa.Tracks.Tracks {
+			eds[i].explicit = eds[i].explicit || t.Explicit
+		}
+	}
+	sortEditions(alb, eds, s.editions)
+	sorted := make([]spotify.SimpleAlbum, len(eds))
+	for i, ed := range eds {
+		sorted[i] = ed.al
+	}
+	return sorted
+}
+
+// sortEditions sorts eds, editions of local, by the preferences prefs.
+func sortEditions(local *media.AlbumMetadata, eds []*edition, prefs []string) {
+	sort.SliceStable(eds, func(i, j int) bool {
+		for _, e := range prefs {
+			if c := editionCompare[e](local, eds[i], eds[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// pick chooses which of matches, all matching alb, to sync: one available
+// in the market, then by duration and edition. The choice and runners-up
+// are reported.
+func (s *syncer) pick(alb *media.AlbumMetadata, matches []spotify.SimpleAlbum) spotify.SimpleAlbum {
+	ms := s.byEdition(alb, s.byDuration(alb, s.inMarket(alb, matches)))
+	if len(ms) > 1 {
+		fmt.Println("Chose", describeEdition(ms[0]), "over:")
+		for _, al := range ms[1:] {
+			fmt.Println("   ", describeEdition(al))
+		}
+	}
+	return ms[0]
+}
+
+func describeEdition(al spotify.SimpleAlbum) string {
+	if al.ReleaseDate == "" {
+		return fmt.Sprintf("%s [%s]", al.Name, al.ID)
+	}
+	return fmt.Sprintf("%s (%s) [%s]", al.Name, al.ReleaseDate, al.ID)
+}
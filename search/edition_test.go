@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestSortEditions(t *testing.T) {
+	local := &media.AlbumMetadata{Tracks: make([]media.Track, 10)}
+	remaster := &edition{al: spotify.SimpleAlbum{ID: "remaster", ReleaseDate: "2011-09-26"}, tracks: 10, explicit: true}
+	original := &edition{al: spotify.SimpleAlbum{ID: "original", ReleaseDate: "1979"}, tracks: 9}
+	deluxe := &edition{al: spotify.SimpleAlbum{ID: "deluxe", ReleaseDate: "2019-11-29"}, tracks: 24, deluxe: true}
+	undated := &edition{al: spotify.SimpleAlbum{ID: "undated"}, tracks: 10}
+	cases := []struct {
+		prefs string
+		want  string
+	}{
+		{prefs: "", want: "remaster,original,deluxe,undated"},
+		{prefs: "original", want: "original,remaster,deluxe,undated"},
+		{prefs: "tracks", want: "remaster,undated,original,deluxe"},
+		{prefs: "tracks,original", want: "remaster,undated,original,deluxe"},
+		{prefs: "deluxe", want: "deluxe,remaster,original,undated"},
+		{prefs: "clean,original", want: "original,deluxe,undated,remaster"},
+		{prefs: "explicit", want: "remaster,original,deluxe,undated"},
+	}
+	for _, tc := range cases {
+		prefs, err := parseEditions(tc.prefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eds := []*edition{remaster, original, deluxe, undated}
+		sortEditions(local, eds, prefs)
+		got := make([]string, len(eds))
+		for i, ed := range eds {
+			got[i] = string(ed.al.ID)
+		}
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("sortEditions(%q): got %v, want %s", tc.prefs, got, tc.want)
+		}
+	}
+	if _, err := parseEditions("original,newest"); err == nil {
+		t.Errorf("parseEditions(original,newest): got no error")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+// EXPORT_SAVED is the name to export to get the saved albums rather than
+// a playlist.
+const EXPORT_SAVED = "saved"
+
+// localTrack is a track of the local library, with the album it's on.
+type localTrack struct {
+	album *media.AlbumMetadata
+	track media.Track
+}
+
+// catalog finds the local files for Spotify tracks.
+type catalog struct {
+	// byArtist is keyed by each of artistKeys of the album's artist, or
+	// for compilations, the track's.
+	byArtist map[string][]localTrack
+}
+
+// newCatalog builds a catalog of every album in idx, so the library must
+// have been scanned first.
+func newCatalog(idx media.Index) (*catalog, error) {
+	albums, err := indexedAlbums(idx)
+	if err != nil {
+		return nil, err
+	}
+	c := &catalog{byArtist: make(map[string][]localTrack)}
+	for _, a := range albums {
+		for _, t := range a.Tracks {
+			artist := a.Artist
+			if t.Artist != "" {
+				artist = t.Artist
+			}
+			for _, k := range artistKeys(artist) {
+				c.byArtist[k] = append(c.byArtist[k], localTrack{album: a, track: t})
+			}
+		}
+	}
+	return c, nil
+}
+
+// indexedAlbums returns every album in idx, in path order.
+func indexedAlbums(idx media.Index) ([]*media.AlbumMetadata, error) {
+	paths, err := idx.AlbumPaths()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	albums := make([]*media.AlbumMetadata, 0, len(paths))
+	for _, p := range paths {
+		a, err := idx.LookupAlbum(p)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, a.Album)
+	}
+	return albums, nil
+}
+
+// find returns the local track best matching ft, preferring one on the
+// same album, or nil if there's none.
+func (c *catalog) find(ft spotify.FullTrack) *localTrack {
+	for _, ar := range ft.Artists {
+		lts := make([]localTrack, 0)
+		for _, k := range artistKeys(ar.Name) {
+			lts = append(lts, c.byArtist[k]...)
+		}
+		ms, _ := bestMatchesBy(ar.Name, ft.Name, lts, func(lt localTrack) (string, []spotify.SimpleArtist) {
+			return lt.track.Title, []spotify.SimpleArtist{{Name: ar.Name}}
+		})
+		if len(ms) == 0 {
+			continue
+		}
+		album := titleKeys(ft.Album.Name)
+		for _, m := range ms {
+			if anyKey(titleKeys(m.album.Name), album, equal) {
+				return &m
+			}
+		}
+		return &ms[0]
+	}
+	return nil
+}
+
+// exportPlaylist writes the playlist called name, or the saved albums if
+// name is EXPORT_SAVED, as an M3U8 in dir of the matching local files.
+// Tracks with no local file are reported and left out.
+func (s *syncer) exportPlaylist(c *catalog, name string, dir string) error {
+	var tracks []spotify.FullTrack
+	var err error
+	if name == EXPORT_SAVED {
+		tracks, err = s.savedTracks()
+	} else {
+		tracks, err = s.playlistTracks(name)
+	}
+	if err != nil {
+		return err
+	}
+	pl := &media.Playlist{Name: name}
+	for _, ft := range tracks {
+		artist := ""
+		if len(ft.Artists) > 0 {
+			artist = ft.Artists[0].Name
+		}
+		lt := c.find(ft)
+		if lt == nil {
+			fmt.Println("!! Not in local library:", artist, "/", ft.Album.Name, "/", ft.Name)
+			continue
+		}
+		pl.Entries = append(pl.Entries, media.PlaylistEntry{
+			Location: localPath(path.Join(lt.album.Path, lt.track.Filename)),
+			Title:    artist + " - " + ft.Name,
+			Duration: lt.track.Duration,
+		})
+	}
+	out := filepath.Join(dir, strings.ReplaceAll(name, string(filepath.Separator), "_")+".m3u8")
+	fmt.Println("Writing", len(pl.Entries), "of", len(tracks), "tracks to", out)
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := media.WritePlaylist(f, pl); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// playlistTracks returns the tracks of the playlist called name.
+func (s *syncer) playlistTracks(name string) ([]spotify.FullTrack, error) {
+	p, err := s.findPlaylist(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("no playlist called %q", name)
+	}
+	page, err := s.client.GetPlaylistItems(s.ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]spotify.FullTrack, 0)
+	for {
+		for _, it := range page.Items {
+			// Episodes and the like have no track.
+			if it.Track.Track != nil {
+				tracks = append(tracks, *it.Track.Track)
+			}
+		}
+		err := s.client.NextPage(s.ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return tracks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// savedTracks returns every track of the user's saved albums, album by
+// album.
+func (s *syncer) savedTracks() ([]spotify.FullTrack, error) {
+	saved, err := s.savedAlbums()
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]spotify.FullTrack, 0)
+	for _, sa := range saved {
+		ts, err := s.albumTracks(&sa.FullAlbum)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range ts {
+			tracks = append(tracks, spotify.FullTrack{SimpleTrack: t, Album: sa.SimpleAlbum})
+		}
+	}
+	return tracks, nil
+}
+
+// savedAlbums returns all of the user's saved albums.
+func (s *syncer) savedAlbums() ([]spotify.SavedAlbum, error) {
+	page, err := s.client.CurrentUsersAlbums(s.ctx, spotify.Limit(50))
+	if err != nil {
+		return nil, err
+	}
+	saved := make([]spotify.SavedAlbum, 0)
+	for {
+		saved = append(saved, page.Albums...)
+		err := s.client.NextPage(s.ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return saved, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// albumTracks returns all of fa's tracks, fetching any beyond the first
+// page.
+func (s *syncer) albumTracks(fa *spotify.FullAlbum) ([]spotify.SimpleTrack, error) {
+	page := fa.Tracks
+	tracks := append([]spotify.SimpleTrack{}, page.Tracks...)
+	for {
+		err := s.client.NextPage(s.ctx, &page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return tracks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, page.Tracks...)
+	}
+}
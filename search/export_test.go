@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+type fakeIndex map[string]*media.IndexedAlbum
+
+func (f fakeIndex) LookupAlbum(path string) (*media.IndexedAlbum, error) {
+	if a, ok := f[path]; ok {
+		return a, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f fakeIndex) UpsertAlbum(a *media.IndexedAlbum) error {
+	f[a.Path] = a
+	return nil
+}
+
+func (f fakeIndex) DeleteAlbum(path string) error {
+	delete(f, path)
+	return nil
+}
+
+func (f fakeIndex) AlbumPaths() ([]string, error) {
+	paths := make([]string, 0, len(f))
+	for p := range f {
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+func TestCatalogFind(t *testing.T) {
+	idx := fakeIndex{}
+	for _, a := range []*media.AlbumMetadata{
+		{Artist: "Artist", Name: "Title", Path: "music/Artist/Title", Tracks: []media.Track{
+			{Title: "One", Filename: "01 One.mp3"},
+			{Title: "Two", Filename: "02 Two.mp3"},
+		}},
+		{Artist: "Artist", Name: "Best Of", Path: "music/Artist/Best Of", Tracks: []media.Track{
+			{Title: "Two", Filename: "01 Two.mp3"},
+		}},
+		{Artist: media.VariousArtists, Name: "Mix", Path: "music/Mix", Tracks: []media.Track{
+			{Artist: "Other", Title: "Three", Filename: "01 Other - Three.mp3"},
+		}},
+	} {
+		idx.UpsertAlbum(&media.IndexedAlbum{Path: a.Path, Album: a})
+	}
+	c, err := newCatalog(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := func(artist, album, name string) spotify.FullTrack {
+		ft := spotify.FullTrack{Album: spotify.SimpleAlbum{Name: album}}
+		ft.Name = name
+		ft.Artists = []spotify.SimpleArtist{{Name: artist}}
+		return ft
+	}
+	cases := []struct {
+		track    spotify.FullTrack
+		wantPath string
+	}{
+		{track: track("Artist", "Title", "One"), wantPath: "music/Artist/Title/01 One.mp3"},
+		{track: track("Artist", "Best Of", "Two"), wantPath: "music/Artist/Best Of/01 Two.mp3"},
+		{track: track("Artist", "Title", "Two"), wantPath: "music/Artist/Title/02 Two.mp3"},
+		{track: track("Artist", "Title", "One - Remastered"), wantPath: "music/Artist/Title/01 One.mp3"},
+		{track: track("Other", "Other's Album", "Three"), wantPath: "music/Mix/01 Other - Three.mp3"},
+		{track: track("Artist", "Title", "Four")},
+		{track: track("Nobody", "Title", "One")},
+	}
+	for _, tc := range cases {
+		got := ""
+		if lt := c.find(tc.track); lt != nil {
+			got = lt.album.Path + "/" + lt.track.Filename
+		}
+		if got != tc.wantPath {
+			t.Errorf("find(%s / %s / %s): got %q, want %q", tc.track.Artists[0].Name, tc.track.Album.Name, tc.track.Name, got, tc.wantPath)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+// resolver reads the metadata of a single local audio file.
+type resolver interface {
+	Resolve(path string) (*media.AlbumMetadata, error)
+}
+
+// importPlaylist recreates the local playlist at name within fsys as a
+// Spotify playlist of the same name, in the same order. Entries which
+// can't be found locally are searched for by the playlist's own title for
+// them, if it has one.
+func (s *syncer) importPlaylist(r resolver, fsys fs.FS, name string) error {
+	pl, err := media.ReadPlaylist(fsys, name)
+	if err != nil {
+		return err
+	}
+	return s.syncPlaylist(r, pl, "Imported from "+localPath(pl.Path))
+}
+
+// syncPlaylist makes the Spotify playlist of the same name as pl hold the
+// Spotify equivalents of pl's entries, reporting those it can't find.
+func (s *syncer) syncPlaylist(r resolver, pl *media.Playlist, description string) error {
+	fmt.Println(">> Syncing playlist", pl.Name)
+	ids := make([]spotify.ID, 0, len(pl.Entries))
+	unresolved := make([]media.PlaylistEntry, 0)
+	for _, e := range pl.Entries {
+		alb := entryAlbum(r, e)
+		if alb == nil {
+			unresolved = append(unresolved, e)
+			continue
+		}
+		tr, err := s.matchTrack(alb, alb.Tracks[0])
+		if err != nil {
+			return err
+		}
+		chosen := make([]spotify.FullTrack, 0)
+		if len(tr.Candidates) > 0 {
+			chosen = s.chooseTracks([]cache.TrackReview{tr})
+		}
+		if len(chosen) == 0 {
+			unresolved = append(unresolved, e)
+			continue
+		}
+		ids = append(ids, chosen[0].ID)
+	}
+	for _, e := range unresolved {
+		what := e.Location
+		if what == "" {
+			what = e.Title
+		}
+		fmt.Println("!! Unresolved entry in", pl.Name+":", what)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	fmt.Println("Setting playlist", pl.Name, "to", len(ids), "tracks")
+	return s.setPlaylist(pl.Name, description, ids)
+}
+
+// entryAlbum returns what's known of a playlist entry, as an album of one
+// track, or nil if nothing useful is.
+func entryAlbum(r resolver, e media.PlaylistEntry) *media.AlbumMetadata {
+	if e.Path != "" {
+		alb, err := r.Resolve(e.Path)
+		if err != nil {
+			log.Println("[warn]", err)
+		}
+		if alb != nil {
+			if alb.Tracks[0].Duration == 0 {
+				alb.Tracks[0].Duration = e.Duration
+			}
+			return alb
+		}
+	}
+	if e.Title == "" {
+		return nil
+	}
+	// Players mostly write "Artist - Title".
+	t := media.Track{Title: e.Title, Duration: e.Duration}
+	if artist, title, ok := strings.Cut(e.Title, " - "); ok {
+		t.Artist, t.Title = artist, title
+	}
+	return &media.AlbumMetadata{Artist: media.VariousArtists, Tracks: []media.Track{t}}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+type fakeResolver map[string]*media.AlbumMetadata
+
+func (f fakeResolver) Resolve(path string) (*media.AlbumMetadata, error) {
+	if a, ok := f[path]; ok {
+		return a, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestEntryAlbum(t *testing.T) {
+	r := fakeResolver{
+		"music/Artist/Title/01 One.mp3": {
+			Artist: "Artist",
+			Name:   "Title",
+			Tracks: []media.Track{{Number: 1, Title: "One"}},
+		},
+	}
+	cases := []struct {
+		entry media.PlaylistEntry
+		want  *media.AlbumMetadata
+	}{
+		{
+			entry: media.PlaylistEntry{Path: "music/Artist/Title/01 One.mp3", Duration: 200 * time.Second},
+			want: &media.AlbumMetadata{
+				Artist: "Artist",
+				Name:   "Title",
+				Tracks: []media.Track{{Number: 1, Title: "One", Duration: 200 * time.Second}},
+			},
+		},
+		{
+			entry: media.PlaylistEntry{Path: "music/Elsewhere/02 Two.mp3", Title: "Other - Two"},
+			want: &media.AlbumMetadata{
+				Artist: media.VariousArtists,
+				Tracks: []media.Track{{Artist: "Other", Title: "Two"}},
+			},
+		},
+		{
+			entry: media.PlaylistEntry{Title: "Three"},
+			want: &media.AlbumMetadata{
+				Artist: media.VariousArtists,
+				Tracks: []media.Track{{Title: "Three"}},
+			},
+		},
+		{entry: media.PlaylistEntry{Location: "http://example.com/stream.mp3"}},
+	}
+	for _, tc := range cases {
+		if diff := cmp.Diff(tc.want, entryAlbum(r, tc.entry)); diff != "" {
+			t.Errorf("entryAlbum(%+v) mismatch (-want +got):\n%s", tc.entry, diff)
+		}
+	}
+}
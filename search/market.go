@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+// marketOpts restricts requests to s.market, if there is one.
+func (s *syncer) marketOpts() []spotify.RequestOption {
+	if s.market == "" {
+		return nil
+	}
+	return []spotify.RequestOption{spotify.Market(s.market)}
+}
+
+// available reports whether al can be played in market. Albums which
+// don't list their markets, as when they were asked for in one, are taken
+// to be.
+func available(al spotify.SimpleAlbum, market string) bool {
+	return market == "" || len(al.AvailableMarkets) == 0 || slices.Contains(al.AvailableMarkets, market)
+}
+
+// inMarket narrows matches for alb to those available in s.market. If
+// none are, alb is flagged and they're all returned.
+func (s *syncer) inMarket(alb *media.AlbumMetadata, matches []spotify.SimpleAlbum) []spotify.SimpleAlbum {
+	avail := make([]spotify.SimpleAlbum, 0, len(matches))
+	for _, al := range matches {
+		if available(al, s.market) {
+			avail = append(avail, al)
+		}
+	}
+	if len(avail) > 0 {
+		return avail
+	}
+	fmt.Println("!! Only found unavailable in", s.market+":", alb.Artist, "/", alb.Name, "from", localPath(alb.Path))
+	return matches
+}
+
+// unavailable reports whether alb, not found in s.market, can be found
+// outside it, flagging it if so. Searches in a market only find what's
+// playable there, so this searches again without one, a page deep.
+func (s *syncer) unavailable(alb *media.AlbumMetadata, artName string, albName string) (bool, error) {
+	if s.market == "" {
+		return false, nil
+	}
+	anywhere := *s
+	anywhere.market, anywhere.depth = "", 1
+	found, err := anywhere.searchAlbum(alb, artName, albName)
+	if err != nil {
+		return false, err
+	}
+	for _, al := range found.matches {
+		if !available(al, s.market) {
+			fmt.Println("!! Only found unavailable in", s.market+":", alb.Artist, "/", alb.Name, "from", localPath(alb.Path))
+			return true, nil
+		}
+	}
+	return false, nil
+}
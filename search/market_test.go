@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestInMarket(t *testing.T) {
+	alb := &media.AlbumMetadata{Artist: "Artist", Name: "Album"}
+	us := spotify.SimpleAlbum{ID: "us", AvailableMarkets: []string{"US", "CA"}}
+	gb := spotify.SimpleAlbum{ID: "gb", AvailableMarkets: []string{"GB"}}
+	unlisted := spotify.SimpleAlbum{ID: "unlisted"}
+	cases := []struct {
+		market  string
+		matches []spotify.SimpleAlbum
+		want    []spotify.SimpleAlbum
+	}{
+		{market: "GB", matches: []spotify.SimpleAlbum{us, gb}, want: []spotify.SimpleAlbum{gb}},
+		{market: "GB", matches: []spotify.SimpleAlbum{us, unlisted}, want: []spotify.SimpleAlbum{unlisted}},
+		// Only unavailable, so all are kept.
+		{market: "JP", matches: []spotify.SimpleAlbum{us, gb}, want: []spotify.SimpleAlbum{us, gb}},
+		{market: "", matches: []spotify.SimpleAlbum{us, gb}, want: []spotify.SimpleAlbum{us, gb}},
+	}
+	for _, tc := range cases {
+		s := &syncer{market: tc.market}
+		if diff := cmp.Diff(tc.want, s.inMarket(alb, tc.matches)); diff != "" {
+			t.Errorf("inMarket(%q) -want, +got: %s", tc.market, diff)
+		}
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	f := &fakeSearcher{pages: []string{
+		`{"albums": {"items": [{"id": "1", "name": "Album", "album_type": "album", "available_markets": ["US"], "artists": [{"id": "a", "name": "Artist"}]}]}}`,
+	}}
+	alb := &media.AlbumMetadata{Artist: "Artist", Name: "Album"}
+	for _, tc := range []struct {
+		market string
+		want   bool
+	}{
+		{market: "GB", want: true},
+		{market: "US", want: false},
+		{market: "", want: false},
+	} {
+		s := &syncer{searcher: f, c: c, market: tc.market, depth: 3, queries: []string{QUERY_PLAIN}}
+		got, err := s.unavailable(alb, "Artist", "Album")
+		if err != nil || got != tc.want {
+			t.Errorf("unavailable in %q: got %t, %v, want %t", tc.market, got, err, tc.want)
+		}
+	}
+}
+
+func TestSearchLegacyKey(t *testing.T) {
+	c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	want := &spotify.SearchResult{Albums: &spotify.SimpleAlbumPage{Albums: []spotify.SimpleAlbum{{ID: "1"}}}}
+	if err := c.UpsertSearch("Artist Album", want); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSearcher{}
+	s := &syncer{searcher: f, c: c, market: "GB"}
+	got, err := s.search("Artist Album", searchType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want.Albums.Albums, got.Albums.Albums); diff != "" || f.searches != 0 {
+		t.Errorf("search: searched Spotify %d times, want 0; -want, +got: %s", f.searches, diff)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+// durationScore rates how well the local tracks' durations agree with a
+// Spotify album's: the fraction of tracks, position by position, within
+// tol of one another, less the relative difference in total duration.
+// Remasters mostly score close to 1, live albums and radio edits well
+// below.
+func durationScore(local []media.Track, remote []spotify.SimpleTrack, tol time.Duration) float64 {
+	n := max(len(local), len(remote))
+	if n == 0 {
+		return 0
+	}
+	matched := 0
+	var lt, rt time.Duration
+	for i, t := range local {
+		lt += t.Duration
+		if i >= len(remote) || t.Duration == 0 {
+			continue
+		}
+		d := t.Duration - time.Duration(remote[i].Duration)*time.Millisecond
+		if d.Abs() <= tol {
+			matched++
+		}
+	}
+	for _, t := range remote {
+		rt += time.Duration(t.Duration) * time.Millisecond
+	}
+	if lt == 0 {
+		return 0
+	}
+	return float64(matched)/float64(n) - (lt-rt).Abs().Seconds()/lt.Seconds()
+}
+
+// album fetches an album's full details, going via the cache.
+func (s *syncer) album(id spotify.ID) (*spotify.FullAlbum, error) {
+	if fa, err := s.c.SpotifyAlbum(id); err == nil {
+		return fa, nil
+	}
+	fa, err := s.client.GetAlbum(s.ctx, id, s.marketOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.c.UpsertSpotifyAlbum(fa); err != nil {
+		log.Println("[warn] Failed to upsert album into cache:", err)
+	}
+	return fa, nil
+}
+
+// byDuration orders albums which otherwise match equally well by how well
+// their track durations agree with alb's, best first. It leaves them be if
+// alb's durations aren't known.
+func (s *syncer) byDuration(alb *media.AlbumMetadata, albums []spotify.SimpleAlbum) []spotify.SimpleAlbum {
+	if len(albums) < 2 || alb.Duration == 0 {
+		return albums
+	}
+	scores := make(map[spotify.ID]float64)
+	for _, al := range albums {
+		fa, err := s.album(al.ID)
+		if err != nil {
+			log.Println("[warn] Failed to get album for duration check:", err)
+			return albums
+		}
+		scores[al.ID] = durationScore(alb.Tracks, fa.Tracks.Tracks, *toleranceFlag)
+		debug("%s / %s duration score %.2f\n", al.ID, al.Name, scores[al.ID])
+	}
+	sorted := append([]spotify.SimpleAlbum{}, albums...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return scores[sorted[i].ID] > scores[sorted[j].ID]
+	})
+	log.Printf("[info] %s has the closest track durations of %d candidates\n", sorted[0].Name, len(sorted))
+	return sorted
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestBestMatches(t *testing.T) {
+	albums := []spotify.SimpleAlbum{
+		{ID: "1", Name: "Title (Live)", Artists: []spotify.SimpleArtist{{Name: "Artist"}}},
+		{ID: "2", Name: "Title", Artists: []spotify.SimpleArtist{{Name: "Someone Else"}}},
+		{ID: "3", Name: "Title", Artists: []spotify.SimpleArtist{{Name: "Artist"}}},
+		{ID: "4", Name: "TITLE", Artists: []spotify.SimpleArtist{{Name: "Guest"}, {Name: "Artist"}}},
+	}
+	cases := []struct {
+		artist, album string
+		wantIDs       []spotify.ID
+		wantMatch     int
+	}{
+		{artist: "Artist", album: "Title", wantIDs: []spotify.ID{"3", "4"}, wantMatch: MATCH_EXACT},
+		{artist: "Artist", album: "Tit", wantIDs: []spotify.ID{"1", "3", "4"}, wantMatch: MATCH_SRC_PREFIX},
+		{artist: "Artist", album: "Title (Live) [Bonus]", wantIDs: []spotify.ID{"1", "3", "4"}, wantMatch: MATCH_DST_PREFIX},
+		{artist: "Nobody", album: "Title", wantMatch: MATCH_UNKNOWN},
+		{artist: "The Artist", album: "Title [Deluxe Edition]", wantIDs: []spotify.ID{"3", "4"}, wantMatch: MATCH_EXACT},
+	}
+	for _, tc := range cases {
+		ms, match := bestMatches(tc.artist, tc.album, albums)
+		ids := make([]spotify.ID, 0)
+		for _, m := range ms {
+			ids = append(ids, m.ID)
+		}
+		if match != tc.wantMatch || len(ids) != len(tc.wantIDs) {
+			t.Errorf("bestMatches(%q, %q): got %v, %d, want %v, %d", tc.artist, tc.album, ids, match, tc.wantIDs, tc.wantMatch)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.wantIDs[i] {
+				t.Errorf("bestMatches(%q, %q): got %v, want %v", tc.artist, tc.album, ids, tc.wantIDs)
+				break
+			}
+		}
+	}
+}
+
+func TestDurationScore(t *testing.T) {
+	local := []media.Track{
+		{Title: "One", Duration: 200 * time.Second},
+		{Title: "Two", Duration: 180 * time.Second},
+		{Title: "Three", Duration: 240 * time.Second},
+	}
+	remote := func(ms ...int) []spotify.SimpleTrack {
+		ts := make([]spotify.SimpleTrack, len(ms))
+		for i, m := range ms {
+			if err := json.Unmarshal([]byte(fmt.Sprintf(`{"duration_ms": %d}`, m)), &ts[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ts
+	}
+	original := durationScore(local, remote(201000, 179000, 240500), 3*time.Second)
+	live := durationScore(local, remote(260000, 230000, 300000), 3*time.Second)
+	deluxe := durationScore(local, remote(200000, 180000, 240000, 190000, 210000), 3*time.Second)
+	if original <= live || original <= deluxe {
+		t.Errorf("original scored %.2f, live %.2f, deluxe %.2f; want original best", original, live, deluxe)
+	}
+	if unknown := durationScore([]media.Track{{Title: "One"}}, remote(200000), 3*time.Second); unknown != 0 {
+		t.Errorf("unknown durations scored %.2f, want 0", unknown)
+	}
+}
+
+func TestBestTracks(t *testing.T) {
+	track := func(id spotify.ID, name, artist string, ms int) spotify.FullTrack {
+		var ft spotify.FullTrack
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"duration_ms": %d}`, ms)), &ft); err != nil {
+			t.Fatal(err)
+		}
+		ft.ID = id
+		ft.Name = name
+		ft.Artists = []spotify.SimpleArtist{{Name: artist}}
+		return ft
+	}
+	tracks := []spotify.FullTrack{
+		track("1", "Title - Live", "Artist", 300000),
+		track("2", "Title", "Artist", 260000),
+		track("3", "Title", "Artist", 201000),
+		track("4", "Title", "Someone Else", 200000),
+	}
+	cases := []struct {
+		artist    string
+		track     media.Track
+		wantIDs   []spotify.ID
+		wantMatch int
+	}{
+		{artist: "Artist", track: media.Track{Title: "Title"}, wantIDs: []spotify.ID{"2", "3"}, wantMatch: MATCH_EXACT},
+		{artist: "Artist", track: media.Track{Title: "Title", Duration: 200 * time.Second}, wantIDs: []spotify.ID{"3", "2"}, wantMatch: MATCH_EXACT},
+		{artist: "Artist", track: media.Track{Title: "Title (Live)"}, wantIDs: []spotify.ID{"1"}, wantMatch: MATCH_EXACT},
+		{artist: "", track: media.Track{Title: "Title", Duration: 200 * time.Second}, wantIDs: []spotify.ID{"4", "3", "2", "1"}, wantMatch: MATCH_SRC_PREFIX},
+		{artist: "Nobody", track: media.Track{Title: "Title"}, wantMatch: MATCH_UNKNOWN},
+	}
+	for _, tc := range cases {
+		ms, match := bestTracks(tc.artist, tc.track, tracks)
+		ids := make([]spotify.ID, 0)
+		for _, m := range ms {
+			ids = append(ids, m.ID)
+		}
+		if match != tc.wantMatch || fmt.Sprint(ids) != fmt.Sprint(tc.wantIDs) {
+			t.Errorf("bestTracks(%q, %q): got %v, %d, want %v, %d", tc.artist, tc.track.Title, ids, match, tc.wantIDs, tc.wantMatch)
+		}
+	}
+}
+
+func TestBestMatchesTransliterated(t *testing.T) {
+	albums := []spotify.SimpleAlbum{
+		{ID: "1", Name: "Gruppa krovi", Artists: []spotify.SimpleArtist{{Name: "Kino"}}},
+	}
+	if ms, _ := bestMatches("Кино", "Группа крови", albums); len(ms) != 0 {
+		t.Errorf("bestMatches without -transliterate: got %v, want none", ms)
+	}
+	*translitFlag = true
+	defer func() { *translitFlag = false }()
+	ms, match := bestMatches("Кино", "Группа крови", albums)
+	if len(ms) != 1 || match != MATCH_EXACT {
+		t.Errorf("bestMatches with -transliterate: got %v, %d, want album 1, %d", ms, match, MATCH_EXACT)
+	}
+}
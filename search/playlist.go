@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// playlistChunk is the most tracks Spotify accepts in a single request to
+// add to a playlist.
+const playlistChunk = 100
+
+// findPlaylist returns the user's playlist called name, or nil if they
+// have none. Unless own is set, playlists they follow count too.
+func (s *syncer) findPlaylist(name string, own bool) (*spotify.SimplePlaylist, error) {
+	page, err := s.client.CurrentUsersPlaylists(s.ctx, spotify.Limit(50))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for _, p := range page.Playlists {
+			if p.Name == name && (!own || p.Owner.ID == s.user) {
+				return &p, nil
+			}
+		}
+		err := s.client.NextPage(s.ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// setPlaylist makes the user's playlist called name hold exactly ids, in
+// order, creating it if need be.
+func (s *syncer) setPlaylist(name string, description string, ids []spotify.ID) error {
+	p, err := s.findPlaylist(name, true)
+	if err != nil {
+		return err
+	}
+	var id spotify.ID
+	if p != nil {
+		id = p.ID
+	} else {
+		log.Println("[info] Creating playlist", name)
+		fp, err := s.client.CreatePlaylistForUser(s.ctx, s.user, name, description, false, false)
+		if err != nil {
+			return err
+		}
+		id = fp.ID
+	}
+	first := ids[:min(len(ids), playlistChunk)]
+	if err := s.client.ReplacePlaylistTracks(s.ctx, id, first...); err != nil {
+		return err
+	}
+	for i := len(first); i < len(ids); i += playlistChunk {
+		if _, err := s.client.AddTracksToPlaylist(s.ctx, id, ids[i:min(len(ids), i+playlistChunk)]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
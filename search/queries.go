@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync"
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	// QUERY_FIELDS searches with field filters: artist:"..." album:"...".
+	QUERY_FIELDS = "fields"
+	// QUERY_PLAIN searches for the artist and album as plain text.
+	QUERY_PLAIN = "plain"
+	// QUERY_ALBUM searches for the album alone.
+	QUERY_ALBUM = "album"
+	// QUERY_RAW searches for the artist and album as they are locally,
+	// without the name rules.
+	QUERY_RAW = "raw"
+	// QUERY_TRANSLIT searches for the artist and album transliterated.
+	QUERY_TRANSLIT = "translit"
+	// QUERY_YEAR is QUERY_FIELDS restricted to the album's year.
+	QUERY_YEAR = "year"
+	// QUERY_DISCOGRAPHY isn't a search, but is recorded as the strategy
+	// for albums found in a pinned artist's discography.
+	QUERY_DISCOGRAPHY = "discography"
+)
+
+// DefaultQueries is the order query strategies are tried in. Plain text
+// comes first, as it always has, and others cost more searches for each
+// album not found, so are left to be asked for.
+var DefaultQueries = []string{QUERY_PLAIN, QUERY_FIELDS}
+
+// queries build the search text for each strategy from an album and its
+// names after the name rules, returning "" where the strategy doesn't
+// apply.
+var queries = map[string]func(alb *media.AlbumMetadata, artName string, albName string) string{
+	QUERY_FIELDS: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		return fmt.Sprintf("artist:%s album:%s", quote(artName), quote(albName))
+	},
+	QUERY_PLAIN: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		return fmt.Sprintf("%s %s", artName, albName)
+	},
+	QUERY_ALBUM: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		return albName
+	},
+	QUERY_RAW: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		return fmt.Sprintf("%s %s", alb.Artist, alb.Name)
+	},
+	QUERY_TRANSLIT: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		return fmt.Sprintf("%s %s", spotsync.Transliterate(artName), spotsync.Transliterate(albName))
+	},
+	QUERY_YEAR: func(alb *media.AlbumMetadata, artName string, albName string) string {
+		if alb.Year == 0 {
+			return ""
+		}
+		return fmt.Sprintf("artist:%s album:%s year:%d", quote(artName), quote(albName), alb.Year)
+	},
+}
+
+// quote quotes s for a field filter, which can't itself contain quotes.
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// parseQueries checks a comma separated list of query strategies.
+func parseQueries(list string) ([]string, error) {
+	qs := strings.Split(list, ",")
+	for _, q := range qs {
+		if queries[q] == nil {
+			return nil, fmt.Errorf("unknown query strategy %q", q)
+		}
+	}
+	return qs, nil
+}
+
+// albumSearch is what searching for an album found.
+type albumSearch struct {
+	// text is the query which found albums, and strategy its strategy if
+	// any of them matched.
+	text     string
+	strategy string
+	albums   []spotify.SimpleAlbum
+	matches  []spotify.SimpleAlbum
+	match    int
+}
+
+// searchAlbum tries s.queries in order until one finds a match for alb.
+// If none do, it returns the first results found, with no strategy.
+func (s *syncer) searchAlbum(alb *media.AlbumMetadata, artName string, albName string) (*albumSearch, error) {
+	var first *albumSearch
+	tried := make(map[string]bool)
+	for _, q := range s.queries {
+		text := queries[q](alb, artName, albName)
+		if text == "" || tried[text] {
+			continue
+		}
+		tried[text] = true
+		fmt.Println(">> Searching for", text)
+		albums, err := s.searchAlbums(text)
+		if err != nil {
+			return nil, err
+		}
+		if len(albums) == 0 {
+			continue
+		}
+		found := &albumSearch{text: text, albums: albums}
+		found.matches, found.match = s.matchAlbum(alb, artName, albName, found.albums)
+		if len(found.matches) > 0 {
+			log.Printf("[info] Found by the %s query\n", q)
+			found.strategy = q
+			return found, nil
+		}
+		if first == nil {
+			first = found
+		}
+	}
+	if first == nil {
+		first = &albumSearch{}
+	}
+	return first, nil
+}
+
+// reportStrategies prints how many albums each query strategy found.
+func (s *syncer) reportStrategies() {
+	if len(s.strategies) == 0 {
+		return
+	}
+	fmt.Println("Albums found by query strategy:")
+	for _, q := range s.strategyOrder() {
+		fmt.Printf("  %s: %d\n", q, s.strategies[q])
+	}
+}
+
+// strategyOrder returns the strategies which found albums, those which
+// found most first and then by name.
+func (s *syncer) strategyOrder() []string {
+	qs := make([]string, 0, len(s.strategies))
+	for q := range s.strategies {
+		qs = append(qs, q)
+	}
+	sort.Slice(qs, func(i, j int) bool {
+		if s.strategies[qs[i]] != s.strategies[qs[j]] {
+			return s.strategies[qs[i]] > s.strategies[qs[j]]
+		}
+		return qs[i] < qs[j]
+	})
+	return qs
+}
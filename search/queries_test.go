@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestQueries(t *testing.T) {
+	alb := &media.AlbumMetadata{Artist: "The Artist", Name: `Album "One" (Remastered)`, Year: 1999}
+	artName, albName := names.Artist(alb.Artist), names.Title(alb.Name)
+	cases := []struct {
+		query string
+		alb   *media.AlbumMetadata
+		want  string
+	}{
+		{query: QUERY_FIELDS, alb: alb, want: `artist:"Artist" album:"Album One"`},
+		{query: QUERY_PLAIN, alb: alb, want: `Artist Album "One"`},
+		{query: QUERY_ALBUM, alb: alb, want: `Album "One"`},
+		{query: QUERY_RAW, alb: alb, want: `The Artist Album "One" (Remastered)`},
+		{query: QUERY_YEAR, alb: alb, want: `artist:"Artist" album:"Album One" year:1999`},
+		{query: QUERY_YEAR, alb: &media.AlbumMetadata{Artist: alb.Artist, Name: alb.Name}, want: ""},
+	}
+	for _, tc := range cases {
+		if got := queries[tc.query](tc.alb, artName, albName); got != tc.want {
+			t.Errorf("%s query: got %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	if qs, err := parseQueries("plain,year"); err != nil || len(qs) != 2 {
+		t.Errorf("parseQueries(plain,year): got %v, %v", qs, err)
+	}
+	if _, err := parseQueries("plain,bogus"); err == nil {
+		t.Errorf("parseQueries(plain,bogus): got no error")
+	}
+}
+
+func TestMergeAlbums(t *testing.T) {
+	page1 := []spotify.SimpleAlbum{{ID: "1"}, {ID: "2"}}
+	page2 := []spotify.SimpleAlbum{{ID: "2"}, {ID: "3"}, {ID: "1"}}
+	want := []spotify.SimpleAlbum{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	if diff := cmp.Diff(want, mergeAlbums(page1, page2)); diff != "" {
+		t.Errorf("mergeAlbums -want, +got: %s", diff)
+	}
+}
+
+// fakeSearcher serves pages of JSON search results, decoding later pages
+// over the results as the Spotify client does.
+type fakeSearcher struct {
+	pages    []string
+	searches int
+}
+
+func (f *fakeSearcher) Search(ctx context.Context, query string, t spotify.SearchType, opts ...spotify.RequestOption) (*spotify.SearchResult, error) {
+	f.searches++
+	var r spotify.SearchResult
+	return &r, json.Unmarshal([]byte(f.pages[0]), &r)
+}
+
+func (f *fakeSearcher) NextAlbumResults(ctx context.Context, r *spotify.SearchResult) error {
+	var page int
+	if _, err := fmt.Sscan(r.Albums.Next, &page); err != nil {
+		return spotify.ErrNoMorePages
+	}
+	f.searches++
+	return json.Unmarshal([]byte(f.pages[page]), r)
+}
+
+func TestSearchAlbums(t *testing.T) {
+	c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	f := &fakeSearcher{pages: []string{
+		`{"albums": {"items": [{"id": "1", "artists": [{"name": "One"}]}, {"id": "2", "artists": [{"name": "Two"}]}], "next": "1"}}`,
+		`{"albums": {"items": [{"id": "2", "artists": [{"name": "Two"}]}, {"id": "3", "artists": [{"name": "Three"}]}], "next": "2"}}`,
+		`{"albums": {"items": [{"id": "4"}]}}`,
+	}}
+	s := &syncer{searcher: f, c: c, depth: 2}
+	want := []spotify.SimpleAlbum{
+		{ID: "1", Artists: []spotify.SimpleArtist{{Name: "One"}}},
+		{ID: "2", Artists: []spotify.SimpleArtist{{Name: "Two"}}},
+		{ID: "3", Artists: []spotify.SimpleArtist{{Name: "Three"}}},
+	}
+	for _, from := range []string{"Spotify", "the cache"} {
+		got, err := s.searchAlbums("Artist Album")
+		if err != nil {
+			t.Fatalf("searchAlbums from %s: %v", from, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("searchAlbums from %s -want, +got: %s", from, diff)
+		}
+	}
+	if f.searches != 2 {
+		t.Errorf("searchAlbums: searched Spotify %d times, want 2", f.searches)
+	}
+}
+
+func TestPageKey(t *testing.T) {
+	if got := pageKey("Artist Album", 0); got != "Artist Album" {
+		t.Errorf("pageKey(..., 0): got %q, want the key itself", got)
+	}
+	if got, want := pageKey("Artist Album", 1), "Artist Album [page 2]"; got != want {
+		t.Errorf("pageKey(..., 1): got %q, want %q", got, want)
+	}
+}
+
+func TestStrategyOrder(t *testing.T) {
+	s := &syncer{strategies: map[string]int{QUERY_PLAIN: 2, QUERY_YEAR: 5, QUERY_FIELDS: 2, QUERY_ALBUM: 2}}
+	want := []string{QUERY_YEAR, QUERY_ALBUM, QUERY_FIELDS, QUERY_PLAIN}
+	for i := 0; i < 10; i++ {
+		if diff := cmp.Diff(want, s.strategyOrder()); diff != "" {
+			t.Fatalf("strategyOrder -want, +got: %s", diff)
+		}
+	}
+}
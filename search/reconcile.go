@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	ORPHANS_REPORT   = "report"
+	ORPHANS_REMOVE   = "remove"
+	ORPHANS_PLAYLIST = "playlist"
+)
+
+// spotifyOnly is the playlist orphans are collected into.
+const spotifyOnly = "Spotify-only"
+
+// errNoLocal is returned by reconcile when there's no local library to
+// reconcile with, when everything saved would be taken for an orphan.
+var errNoLocal = errors.New("no albums in the local index; sync the library first")
+
+// orphans returns those of saved which match none of local, by the same
+// matching syncAlbum uses in the other direction.
+func orphans(local []*media.AlbumMetadata, saved []spotify.SavedAlbum) []spotify.SavedAlbum {
+	byArtist := make(map[string][]*media.AlbumMetadata)
+	for _, a := range local {
+		for _, k := range artistKeys(a.Artist) {
+			byArtist[k] = append(byArtist[k], a)
+		}
+	}
+	found := make([]spotify.SavedAlbum, 0)
+	for _, sa := range saved {
+		artists := append([]spotify.SimpleArtist{{Name: media.VariousArtists}}, sa.Artists...)
+		if !hasLocal(byArtist, artists, sa.SimpleAlbum) {
+			found = append(found, sa)
+		}
+	}
+	return found
+}
+
+// byArtistKeys returns the albums under any of ar's keys, or those of a
+// local artist ar is an alias of.
+func byArtistKeys(byArtist map[string][]*media.AlbumMetadata, ar spotify.SimpleArtist) []*media.AlbumMetadata {
+	as := make([]*media.AlbumMetadata, 0)
+	for _, k := range artistKeys(ar.Name) {
+		as = append(as, byArtist[k]...)
+	}
+	for k := range aliases {
+		if aliased([]string{k}, ar) {
+			as = append(as, byArtist[k]...)
+		}
+	}
+	return as
+}
+
+func hasLocal(byArtist map[string][]*media.AlbumMetadata, artists []spotify.SimpleArtist, al spotify.SimpleAlbum) bool {
+	for _, ar := range artists {
+		for _, a := range byArtistKeys(byArtist, ar) {
+			artName := a.Artist
+			albName := a.Name
+			if a.Artist == media.VariousArtists {
+				// Compilations are credited to their artists on
+				// Spotify, so only the name can be matched.
+				artName = ""
+			}
+			if ms, _ := bestMatches(artName, albName, []spotify.SimpleAlbum{al}); len(ms) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reconcile reports the user's saved albums with no local counterpart
+// among local, and removes them or collects them into a playlist as
+// action says.
+func (s *syncer) reconcile(local []*media.AlbumMetadata, action string) error {
+	if len(local) == 0 {
+		return errNoLocal
+	}
+	saved, err := s.savedAlbums()
+	if err != nil {
+		return err
+	}
+	found := orphans(local, saved)
+	fmt.Println(len(found), "of", len(saved), "saved albums are not in the local library")
+	if action == ORPHANS_REMOVE && 2*len(found) > len(saved) {
+		// Likely the index is missing much of the library, as when
+		// a scan was cut short.
+		fmt.Println("!! Most saved albums seem not to be local; is the local index complete?")
+		if !s.confirm("Go on to remove them?") {
+			return nil
+		}
+	}
+	ids := make([]spotify.ID, 0)
+	for _, sa := range found {
+		fmt.Println("-- Spotify only:", sa.Artists[0].Name, "/", sa.Name)
+		switch action {
+		case ORPHANS_REMOVE:
+			fmt.Print("Remove from library? [y/N] => ")
+			r, _ := s.reader.ReadString('\n')
+			r = strings.TrimSpace(r)
+			if r != "y" && r != "Y" {
+				continue
+			}
+			if err := s.library.RemoveAlbumsFromLibrary(s.ctx, sa.ID); err != nil {
+				return err
+			}
+		case ORPHANS_PLAYLIST:
+			ts, err := s.albumTracks(&sa.FullAlbum)
+			if err != nil {
+				return err
+			}
+			for _, t := range ts {
+				ids = append(ids, t.ID)
+			}
+		}
+	}
+	if action != ORPHANS_PLAYLIST {
+		return nil
+	}
+	fmt.Println("Setting playlist", spotifyOnly, "to", len(ids), "tracks")
+	return s.setPlaylist(spotifyOnly, "Saved albums not in the local library", ids)
+}
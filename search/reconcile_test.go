@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestOrphans(t *testing.T) {
+	local := []*media.AlbumMetadata{
+		{Artist: "The Artist", Name: "Title"},
+		{Artist: "Artist", Name: "Live"},
+		{Artist: media.VariousArtists, Name: "Mix"},
+	}
+	saved := func(id spotify.ID, artist, name string) spotify.SavedAlbum {
+		var sa spotify.SavedAlbum
+		sa.ID = id
+		sa.Name = name
+		sa.Artists = []spotify.SimpleArtist{{Name: artist}}
+		return sa
+	}
+	got := make([]spotify.ID, 0)
+	for _, sa := range orphans(local, []spotify.SavedAlbum{
+		saved("1", "Artist", "Title"),
+		saved("2", "Artist", "Title (Deluxe Edition)"),
+		saved("3", "Artist", "Other"),
+		saved("4", "Someone", "Mix"),
+		saved("5", "Someone", "Title"),
+		saved("6", "Artist", "Liv"),
+	}) {
+		got = append(got, sa.ID)
+	}
+	if diff := cmp.Diff([]spotify.ID{"3", "5"}, got); diff != "" {
+		t.Errorf("orphans mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOrphansAliased(t *testing.T) {
+	aliases = map[string]*cache.Alias{
+		"prince": {Artist: "Prince", Names: []string{"The Artist Formerly Known As Prince"}},
+	}
+	defer func() { aliases = nil }()
+	local := []*media.AlbumMetadata{{Artist: "Prince", Name: "Chaos and Disorder"}}
+	var sa spotify.SavedAlbum
+	sa.ID = "1"
+	sa.Name = "Chaos and Disorder"
+	sa.Artists = []spotify.SimpleArtist{{Name: "The Artist Formerly Known As Prince"}}
+	if got := orphans(local, []spotify.SavedAlbum{sa}); len(got) != 0 {
+		t.Errorf("orphans: got %v, want none", got)
+	}
+}
+
+func TestReconcileNoLocal(t *testing.T) {
+	s := &syncer{}
+	if err := s.reconcile(nil, ORPHANS_REMOVE); !errors.Is(err, errNoLocal) {
+		t.Errorf("reconcile with no local albums: got %v, want %v", err, errNoLocal)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/zmb3/spotify/v2"
 
@@ -29,12 +32,74 @@ const (
 	MATCH_DST_PREFIX = iota
 )
 
+const (
+	TRACKS_PLAYLIST = "playlist"
+	TRACKS_LIKED    = "liked"
+)
+
 var (
 	cFlag = flag.String("c", "/home/trevors/spotify.db", "Spotify cache sqlite database")
 	dFlag = flag.Bool("d", false, "Enable debugging")
-	lFlag = flag.String("l", "/usr/local/mp3", "Location of mp3 library")
+	lFlag = flag.String("l", "/usr/local/mp3", "Location of mp3 library; comma separated for several")
+
+	settleFlag    = flag.Duration("settle", media.DefaultSettle, "How long an album directory must be unchanged before watch syncs it")
+	probeFlag     = flag.Bool("probe", false, "Read audio file headers for track durations, to break ties between matching albums; slow on network mounts")
+	toleranceFlag = flag.Duration("tolerance", 3*time.Second, "How far local and Spotify track durations may differ and still be the same recording")
+	fullFlag      = flag.Bool("full", false, "Rescan every album, not just those new or changed since the last run")
+	workersFlag   = flag.Int("workers", media.DefaultWorkers, "Number of library directories to read concurrently")
+	patternsFlag  = flag.String("track-patterns", "", "File of regular expressions, one per line, tried in order against track filenames; see media.DefaultTrackPatterns")
+	tracksFlag    = flag.String("tracks", "", "Sync albums not found on Spotify track by track, into a playlist per album (\"playlist\") or Liked Songs (\"liked\")")
+	smartFlag     = flag.String("smart", "", "File of smart playlist definitions, e.g. \"Top rated = rating >= 4\", to keep in sync after syncing albums")
+	orphansFlag   = flag.String("orphans", ORPHANS_REPORT, "What reconcile does with saved albums not in the local library: report, remove (asking first) or playlist (into \""+spotifyOnly+"\")")
+	policyFlag    = flag.String("policy", POLICY_ADD_ONLY, "What to do about albums removed from one side after syncing: mirror (remove them from the other), add-only (remove nothing, re-adding on Spotify) or ask")
+	outFlag       = flag.String("out", ".", "Directory export writes playlists to")
+	namesFlag     = flag.String("name-rules", "", "File of name rules, \"<artist|title|any> <regexp> [=> <replacement>]\" per line, tidying local and Spotify names before comparing; see spotsync.DefaultNameRules")
+	legacyFlag    = flag.Bool("legacy-canon", false, "Compare names as before Unicode normalization, only dropping diacritics already separate from their letters")
+	translitFlag  = flag.Bool("transliterate", false, "Also match names by transliterating kana, Cyrillic and Greek to Latin letters")
+	queriesFlag   = flag.String("queries", strings.Join(DefaultQueries, ","), "Comma separated list of query strategies to search for albums with, tried in order until one finds a match, from plain, fields, album, raw, translit and year")
+	marketFlag    = flag.String("market", "", "Country code of the Spotify market to match albums in; defaults to the logged in user's country")
+	singlesFlag   = flag.String("singles", SINGLES_SHORT, "Which local albums Spotify singles and EPs may match: never, short (those of up to 6 tracks) or always")
+	editionFlag   = flag.String("edition", "", "Comma separated list of preferences for choosing between editions of an album, in order: original, tracks (as many as locally), deluxe, explicit or clean")
+	depthFlag     = flag.Int("depth", 3, "How many pages of search results to look through for each query")
+	layoutsFlag   = flag.String("layouts", strings.Join(media.DefaultLayouts, ","), "Comma separated list of library layout templates, e.g. {genre}/{artist}/{album}/{disc}, tried in order")
 )
 
+// names tidies names before they're compared; nil for DefaultNameRules.
+var names *spotsync.NameRules
+
+// artistKeys and titleKeys reduce names to the forms they're compared in:
+// canonical, and with -transliterate, transliterated too.
+func artistKeys(name string) []string {
+	return keys(names.Artist(name))
+}
+
+func titleKeys(name string) []string {
+	return keys(names.Title(name))
+}
+
+func keys(name string) []string {
+	if *translitFlag {
+		return spotsync.CanonicalKeys(name)
+	}
+	return []string{spotsync.CanonicalizeName(name)}
+}
+
+// anyKey reports whether match holds for any of as with any of bs.
+func anyKey(as []string, bs []string, match func(a, b string) bool) bool {
+	for _, a := range as {
+		for _, b := range bs {
+			if match(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return a == b
+}
+
 func debug(format string, v ...any) {
 	if *dFlag {
 		log.Printf(format, v...)
@@ -42,76 +107,312 @@ func debug(format string, v ...any) {
 }
 
 func bestMatch(artistName string, albumName string, albums []spotify.SimpleAlbum) (*spotify.SimpleAlbum, int) {
-	art := spotsync.CanonicalizeName(artistName)
-	alb := spotsync.CanonicalizeName(albumName)
-	for _, al := range albums {
-		cn := spotsync.CanonicalizeName(al.Name)
+	ms, match := bestMatches(artistName, albumName, albums)
+	if len(ms) == 0 {
+		return nil, MATCH_UNKNOWN
+	}
+	return &ms[0], match
+}
+
+// bestMatches is like bestMatch but returns every album which matches as
+// well as the best, in the order given.
+func bestMatches(artistName string, albumName string, albums []spotify.SimpleAlbum) ([]spotify.SimpleAlbum, int) {
+	return bestMatchesBy(artistName, albumName, albums, func(al spotify.SimpleAlbum) (string, []spotify.SimpleArtist) {
+		return al.Name, al.Artists
+	})
+}
+
+// bestMatchesBy is bestMatches for anything with a name and artists, as
+// given by fields.
+func bestMatchesBy[T any](artistName string, name string, items []T, fields func(T) (string, []spotify.SimpleArtist)) ([]T, int) {
+	art := artistKeys(artistName)
+	want := titleKeys(name)
+	ms := make([]T, 0)
+	for _, it := range items {
+		n, artists := fields(it)
+		cn := titleKeys(n)
 		debug("al cn: %s\n", cn)
-		if cn != alb {
-			debug("%s is not %s\n", alb, cn)
+		if !anyKey(cn, want, equal) {
+			debug("%s is not %s\n", want, cn)
 			continue
 		}
-		for _, ar := range al.Artists {
-			cn = spotsync.CanonicalizeName(ar.Name)
+		for _, ar := range artists {
+			cn = artistKeys(ar.Name)
 			debug("ar cn: %s\n", cn)
-			if cn == art {
-				log.Printf("%s / %s seems to be an exact match\n", ar.Name, al.Name)
-				return &al, MATCH_EXACT
+			if anyKey(cn, art, equal) || aliased(art, ar) {
+				log.Printf("%s / %s seems to be an exact match\n", ar.Name, n)
+				ms = append(ms, it)
+				break
 			}
 		}
 	}
-	for _, al := range albums {
-		cn := spotsync.CanonicalizeName(al.Name)
+	if len(ms) > 0 {
+		return ms, MATCH_EXACT
+	}
+	for _, it := range items {
+		n, artists := fields(it)
+		cn := titleKeys(n)
 		debug("al cn: %s\n", cn)
-		if !strings.HasPrefix(cn, alb) {
-			debug("%s is not %s\n", alb, cn)
+		if !anyKey(cn, want, strings.HasPrefix) {
+			debug("%s is not %s\n", want, cn)
 			continue
 		}
-		for _, ar := range al.Artists {
-			cn = spotsync.CanonicalizeName(ar.Name)
+		for _, ar := range artists {
+			cn = artistKeys(ar.Name)
 			debug("ar cn: %s\n", cn)
-			if strings.HasPrefix(cn, art) {
-				log.Printf("%s / %s seems to be a match\n", ar.Name, al.Name)
-				return &al, MATCH_SRC_PREFIX
+			if anyKey(cn, art, strings.HasPrefix) {
+				log.Printf("%s / %s seems to be a match\n", ar.Name, n)
+				ms = append(ms, it)
+				break
 			}
 		}
 	}
-	for _, al := range albums {
-		cn := spotsync.CanonicalizeName(al.Name)
+	if len(ms) > 0 {
+		return ms, MATCH_SRC_PREFIX
+	}
+	for _, it := range items {
+		n, artists := fields(it)
+		cn := titleKeys(n)
 		debug("al cn: %s\n", cn)
-		if !strings.HasPrefix(alb, cn) {
-			debug("%s is not %s\n", alb, cn)
+		if !anyKey(want, cn, strings.HasPrefix) {
+			debug("%s is not %s\n", want, cn)
 			continue
 		}
-		for _, ar := range al.Artists {
-			cn = spotsync.CanonicalizeName(ar.Name)
+		for _, ar := range artists {
+			cn = artistKeys(ar.Name)
 			debug("ar cn: %s\n", cn)
-			if strings.HasPrefix(cn, art) {
-				log.Printf("%s / %s seems to be a match\n", ar.Name, al.Name)
-				return &al, MATCH_DST_PREFIX
+			if anyKey(cn, art, strings.HasPrefix) {
+				log.Printf("%s / %s seems to be a match\n", ar.Name, n)
+				ms = append(ms, it)
+				break
 			}
 		}
 	}
+	if len(ms) > 0 {
+		return ms, MATCH_DST_PREFIX
+	}
 	return nil, MATCH_UNKNOWN
 }
 
+// readPatterns reads the non-blank lines of file, or returns nil if file
+// is "".
+func readPatterns(file string) ([]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	patterns := make([]string, 0)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, nil
+}
+
+// readLibrary reads a player's library export, by its extension: iTunes'
+// Library.xml, or a CSV or JSON listing.
+func readLibrary(file string) (*media.Library, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".xml":
+		return media.ReadITunesLibrary(f)
+	case ".csv", ".json":
+		return media.ReadListing(f, ext[1:])
+	default:
+		return nil, fmt.Errorf("%s: unknown library format", file)
+	}
+}
+
+// localRoots converts local paths to paths within os.DirFS("/"), exiting
+// if any can't be.
+func localRoots(dirs []string) []string {
+	roots := make([]string, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		if roots[i], err = media.LocalRoot(dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return roots
+}
+
+// localPath converts a path within os.DirFS("/") back to a local one.
+func localPath(p string) string {
+	return filepath.FromSlash("/" + p)
+}
+
 func main() {
 	flag.Parse()
-	m := media.NewDirectoryAlbumProducer(*lFlag, os.ReadDir)
-	go func() {
-		m.Start()
-	}()
+	if *legacyFlag {
+		spotsync.DefaultCanon = spotsync.LegacyCanon
+	}
+	c, err := cache.New(*cFlag, cache.Options{Debug: *dFlag})
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	patterns, err := readPatterns(*patternsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rules, err := readPatterns(*namesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if names, err = spotsync.NewNameRules(rules); err != nil {
+		log.Fatalf("%s: %v", *namesFlag, err)
+	}
+	if aliases, err = loadAliases(c); err != nil {
+		log.Fatal(err)
+	}
+	smart, err := readSmartPlaylists(*smartFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mo := media.Options{
+		Layouts:       strings.Split(*layoutsFlag, ","),
+		Workers:       *workersFlag,
+		Index:         c,
+		Full:          *fullFlag,
+		Probe:         *probeFlag,
+		TrackPatterns: patterns,
+		Settle:        *settleFlag,
+	}
+	switch *tracksFlag {
+	case "", TRACKS_PLAYLIST, TRACKS_LIKED:
+	default:
+		log.Fatalf("Unknown -tracks destination %q", *tracksFlag)
+	}
+	switch *orphansFlag {
+	case ORPHANS_REPORT, ORPHANS_REMOVE, ORPHANS_PLAYLIST:
+	default:
+		log.Fatalf("Unknown -orphans action %q", *orphansFlag)
+	}
+	switch *singlesFlag {
+	case SINGLES_NEVER, SINGLES_SHORT, SINGLES_ALWAYS:
+	default:
+		log.Fatalf("Unknown -singles allowance %q", *singlesFlag)
+	}
+	switch *policyFlag {
+	case POLICY_MIRROR, POLICY_ADD_ONLY, POLICY_ASK:
+	default:
+		log.Fatalf("Unknown -policy %q", *policyFlag)
+	}
+	queries, err := parseQueries(*queriesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	editions, err := parseEditions(*editionFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	roots := strings.Split(*lFlag, ",")
+	var albums media.AlbumIterFn
+	var r resolver
+	var playlists []string
+	var libPlaylists []*media.Playlist
+	var libAlbums []*media.AlbumMetadata
+	var m interface {
+		Err() error
+		Disappeared() []*media.IndexedAlbum
+	}
+	// synced records albums as synced, so later scans skip them.
+	var synced func(*media.AlbumMetadata)
+	switch flag.Arg(0) {
+	case "watch":
+		w, err := media.NewWatcher(roots, mo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			w.Start(ctx)
+		}()
+		albums = w.Albums()
+		synced = w.Synced
+	case "review", "export", "reconcile":
+	case "aliases":
+		// Spotify isn't needed to import aliases.
+		if err := importAliases(c, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "unalias":
+		if err := unalias(c, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "pins":
+		if err := listPins(c); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "unpin":
+		if err := unpin(c, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "playlists":
+		// Playlists are resolved against the library one file at a
+		// time, so the producer is never started.
+		if r, err = media.NewDirectoryAlbumProducer(os.DirFS("/"), localRoots(roots), mo); err != nil {
+			log.Fatal(err)
+		}
+		playlists = localRoots(flag.Args()[1:])
+		if len(playlists) == 0 {
+			if playlists, err = media.FindPlaylists(os.DirFS("/"), localRoots(roots)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "library":
+		lib, err := readLibrary(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			lib.Start(ctx)
+		}()
+		albums = lib.Albums()
+		m = lib
+		r = lib
+		libPlaylists = append(lib.Playlists(), lib.RatingPlaylists()...)
+		libAlbums = lib.AlbumList()
+	default:
+		p, err := media.NewDirectoryAlbumProducer(os.DirFS("/"), localRoots(roots), mo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			p.Start(ctx)
+		}()
+		albums = p.Albums()
+		m = p
+		synced = p.Synced
+	}
 	text := strings.Join(os.Args[1:], " ")
 	if text == "" {
 		log.Fatal("Please supply search terms on the command line")
 	}
-	ctx := context.Background()
 	o := authserver.Options{
 		Debug:        *dFlag,
 		Port:         8080,
 		AuthPath:     "/callback",
 		RedirectHost: "192.168.1.101",
-		Scopes:       []string{spotifyauth.ScopeUserLibraryRead, spotifyauth.ScopeUserLibraryModify},
+		Scopes: []string{
+			spotifyauth.ScopeUserLibraryRead,
+			spotifyauth.ScopeUserLibraryModify,
+			spotifyauth.ScopeUserReadPrivate,
+			spotifyauth.ScopePlaylistReadPrivate,
+			spotifyauth.ScopePlaylistModifyPrivate,
+		},
 	}
 	server := authserver.New(o)
 	go func() {
@@ -136,100 +437,118 @@ func main() {
 		log.Fatal(err)
 	}
 	fmt.Println("You are logged in as:", user.ID)
-
-	c, err := cache.New(*cFlag, cache.Options{Debug: *dFlag})
-	if err != nil {
-		panic(err)
+	market := strings.ToUpper(*marketFlag)
+	if market == "" {
+		market = user.Country
 	}
 
-	for alb := range m.Albums() {
-		artName := strings.TrimPrefix(alb.Artist, "The ")
-		albName := strings.TrimPrefix(alb.Name, "The ")
-//		text := fmt.Sprintf("artist:\"%s\" album:\"%s\"", artName, albName)
-		text := fmt.Sprintf("%s %s", artName, albName)
-		fmt.Println(">> Searching for", text)
+	s := &syncer{
+		ctx:    ctx,
+		client: client,
+		c:      c,
+		reader: bufio.NewReader(os.Stdin),
+		queue:  flag.Arg(0) == "watch",
+		tracks: *tracksFlag,
+		user:   user.ID,
+		policy: *policyFlag,
 
-		// TODO: this should be refactored into e.g. SearchWithCache.
-		results, err := c.Search(text)
-		if err != nil {
-			log.Println("[warn] Cache search failed:", err)
+		searcher:   client,
+		library:    client,
+		market:     market,
+		singles:    *singlesFlag,
+		editions:   editions,
+		depth:      *depthFlag,
+		queries:    queries,
+		strategies: make(map[string]int),
+	}
+	switch flag.Arg(0) {
+	case "review":
+		if err := s.review(); err != nil {
+			log.Fatal(err)
 		}
-		if results == nil {
-			log.Println("[info] Searching Spotify")
-			results, err = client.Search(ctx, text, searchType)
-			if err != nil {
+		return
+	case "playlists":
+		for _, pl := range playlists {
+			if err := s.importPlaylist(r, os.DirFS("/"), pl); err != nil {
 				log.Fatal(err)
 			}
-			if err := c.UpsertSearch(text, results); err != nil {
-				log.Println("[warn] Failed to upsert search into cache:", err)
-			}
-		} else {
-			log.Println("[info] Found results in cache")
 		}
-
-		// handle album results
-		if results.Albums == nil || len(results.Albums.Albums) == 0 {
-			fmt.Println("!! Failed to find", text)
-			continue
+		return
+	case "reconcile":
+		local, err := indexedAlbums(c)
+		if err != nil {
+			log.Fatal(err)
 		}
-		reader := bufio.NewReader(os.Stdin)
-		toAdd := make([]spotify.SimpleAlbum, 0)
-		albums := results.Albums.Albums
-		res, match := bestMatch(artName, albName, albums)
-		if res == nil {
-			log.Println("[warn] Found no good match.")
-		} else {
-			albums = []spotify.SimpleAlbum{*res}
-		}
-		fmt.Println("Albums:")
-		for _, item := range albums {
-
-			fmt.Println("   ", item.Name)
-			fmt.Println("    >> Artists:")
-			for _, artist := range item.Artists {
-				fmt.Println("        ", artist.Name)
-			}
-			has, err := client.UserHasAlbums(ctx, item.ID)
-			if err != nil {
-				fmt.Println("err:", err)
-				continue
-			}
-			if has[0] {
-				fmt.Println("user already has ", item.Artists[0].Name, "/", item.Name, "considered a match")
-				break
-			}
-			if match == MATCH_EXACT {
-				toAdd = append(toAdd, item)
-			} else {
-				log.Println("[info] Match was not exact, so prompting...")
-				fa, err := client.GetAlbum(ctx, item.ID)
-				if err != nil {
-					log.Print(err)
-				}
-				fmt.Println("    >> Tracks:")
-				for _, track := range fa.Tracks.Tracks { // Assume just 1 page
-					fmt.Println("        ", track.Name)
-				}
-				fmt.Print("Add to library? [y/N] => ")
-				r, _ := reader.ReadString('\n')
-				r = strings.TrimSpace(r)
-				if r == "y" || r == "Y" {
-					toAdd = append(toAdd, item)
-					break
-				}
+		if err := s.reconcile(local, *orphansFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "export":
+		cat, err := newCatalog(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := flag.Args()[1:]
+		if len(names) == 0 {
+			names = []string{EXPORT_SAVED}
+		}
+		for _, name := range names {
+			if err := s.exportPlaylist(cat, name, *outFlag); err != nil {
+				log.Fatal(err)
 			}
 		}
-		if len(toAdd) > 0 {
-			fmt.Println("Adding...")
-			ids := make([]spotify.ID, len(toAdd))
-			for i, alb := range toAdd {
-				fmt.Println("    ", alb.Artists[0].Name, " / ", albName)
-				ids[i] = alb.ID
+		return
+	}
+	for alb := range albums {
+		if err := s.syncAlbum(alb); err != nil {
+			if !s.queue {
+				log.Fatal(err)
 			}
-			err = client.AddAlbumsToLibrary(ctx, ids...)
-			if err != nil {
+			// Keep watching; Spotify may well be back by the
+			// time the next album lands.
+			log.Println("[warn]", err)
+			continue
+		}
+		if synced != nil {
+			synced(alb)
+		}
+	}
+	s.reportStrategies()
+	for _, pl := range libPlaylists {
+		if err := s.syncPlaylist(r, pl, "Synced from "+flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !s.queue {
+		if err := s.checkSpotify(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(smart) > 0 && !s.queue {
+		// Smart playlists draw on the whole library, not just what
+		// this run synced.
+		all := libAlbums
+		if all == nil {
+			if all, err = indexedAlbums(c); err != nil {
 				log.Fatal(err)
 			}
 		}
+		// A scan that went wrong may have missed albums.
+		complete := len(all) > 0 && (m == nil || m.Err() == nil)
+		if err := s.syncSmart(smart, all, complete); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if m == nil {
+		return
+	}
+	if err := m.Err(); err != nil {
+		log.Println("[warn] Problems scanning library:", err)
+	}
+	for _, a := range m.Disappeared() {
+		fmt.Println("-- No longer in library:", a.Album.Artist, "/", a.Album.Name, "at", localPath(a.Path))
+		if err := s.localGone(a); err != nil {
+			log.Println("[warn]", err)
+		}
 	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+// readSmartPlaylists reads the smart playlist definitions in file, or
+// returns nil if file is "".
+func readSmartPlaylists(file string) ([]*media.SmartPlaylist, error) {
+	if file == "" {
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sps, err := media.ParseSmartPlaylists(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return sps, nil
+}
+
+// syncSmart makes each smart playlist on Spotify hold exactly the tracks
+// it selects from albums, so that it follows ratings and play counts as
+// they change. Only exact matches are used, since there's nobody to ask
+// about the rest on every run. complete says whether albums is the whole
+// library, without which a playlist selecting nothing is left alone.
+func (s *syncer) syncSmart(sps []*media.SmartPlaylist, albums []*media.AlbumMetadata, complete bool) error {
+	for _, sp := range sps {
+		sel := sp.Select(albums)
+		fmt.Println(">> Syncing smart playlist", sp.Name, "of", len(sel), "tracks")
+		ids := make([]spotify.ID, 0, len(sel))
+		for _, a := range sel {
+			t := a.Tracks[0]
+			tr, err := s.matchTrack(a, t)
+			if err != nil {
+				return err
+			}
+			if !tr.Exact {
+				fmt.Println("!! No exact match for", a.Artist, "/", a.Name, "/", t.Title)
+				continue
+			}
+			ids = append(ids, tr.Candidates[0].ID)
+		}
+		if len(ids) == 0 && (len(sel) > 0 || !complete) {
+			// Emptying the playlist is only right if nothing in the
+			// library is selected, not if tracks weren't found.
+			log.Println("[warn] Found no tracks for smart playlist", sp.Name, "so leaving it be")
+			continue
+		}
+		if err := s.setPlaylist(sp.Name, "Smart playlist synced by spotsync", ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
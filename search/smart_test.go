@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+// TestSyncSmartLeavesEmpty checks that syncSmart doesn't empty playlists
+// when it can't be sure nothing belongs on them. The syncer has no client,
+// so setting a playlist would panic.
+func TestSyncSmartLeavesEmpty(t *testing.T) {
+	c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sps, err := media.ParseSmartPlaylists(strings.NewReader("Top rated = rating >= 4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &syncer{searcher: &fakeSearcher{pages: []string{`{"tracks": {"items": []}}`}}, c: c}
+	rated := func(rating int) []*media.AlbumMetadata {
+		return []*media.AlbumMetadata{{Artist: "Artist", Name: "Title", Tracks: []media.Track{{Title: "One", Rating: rating}}}}
+	}
+	cases := []struct {
+		name     string
+		albums   []*media.AlbumMetadata
+		complete bool
+	}{
+		{name: "no library", complete: false},
+		{name: "incomplete library", albums: rated(20), complete: false},
+		{name: "tracks not on Spotify", albums: rated(100), complete: true},
+	}
+	for _, tc := range cases {
+		if err := s.syncSmart(sps, tc.albums, tc.complete); err != nil {
+			t.Errorf("%s: syncSmart: %v", tc.name, err)
+		}
+	}
+}
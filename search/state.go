@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	// POLICY_MIRROR removes albums from Spotify when they're deleted
+	// locally, and doesn't re-add those removed on Spotify.
+	POLICY_MIRROR = "mirror"
+	// POLICY_ADD_ONLY never removes anything from Spotify, and re-adds
+	// albums removed there.
+	POLICY_ADD_ONLY = "add-only"
+	// POLICY_ASK prompts for each.
+	POLICY_ASK = "ask"
+)
+
+// hasChunk is the most albums Spotify will check the library for at once.
+const hasChunk = 20
+
+// recordSync records that alb is synced to the Spotify album id, found by
+// the query strategy, if known. Failures are only logged, since the album
+// itself was synced.
+func (s *syncer) recordSync(alb *media.AlbumMetadata, id spotify.ID, strategy string) {
+	now := time.Now()
+	st := &cache.SyncState{
+		Path:        alb.Path,
+		Artist:      alb.Artist,
+		Name:        alb.Name,
+		SpotifyID:   id,
+		LocalSeen:   now,
+		SpotifySeen: now,
+		Strategy:    strategy,
+	}
+	if err := s.c.UpsertSyncState(st); err != nil {
+		log.Println("[warn] Failed to record sync state:", err)
+	}
+}
+
+// removedOnSpotify reports whether alb was synced before but has since
+// been removed on Spotify, and left that way. An album back after being
+// deleted locally is synced afresh, whatever became of it on Spotify.
+func (s *syncer) removedOnSpotify(alb *media.AlbumMetadata) bool {
+	st, err := s.c.SyncState(cache.SyncKey(alb.Path, alb.Artist, alb.Name))
+	if err != nil {
+		return false
+	}
+	st.LocalSeen = time.Now()
+	gone := st.LocalGone.IsZero() && !st.SpotifyGone.IsZero()
+	if !st.LocalGone.IsZero() {
+		st.LocalGone, st.SpotifyGone = time.Time{}, time.Time{}
+	}
+	if err := s.c.UpsertSyncState(st); err != nil {
+		log.Println("[warn] Failed to record sync state:", err)
+	}
+	if gone {
+		fmt.Println("-- Removed on Spotify, so not re-adding:", alb.Artist, "/", alb.Name)
+	}
+	return gone
+}
+
+// confirm prompts with question, returning whether the answer was yes.
+func (s *syncer) confirm(question string) bool {
+	fmt.Print(question, " [y/N] => ")
+	r, _ := s.reader.ReadString('\n')
+	r = strings.TrimSpace(r)
+	return r == "y" || r == "Y"
+}
+
+// localGone deals with an album which has disappeared from the local
+// library, according to s.policy.
+func (s *syncer) localGone(a *media.IndexedAlbum) error {
+	st, err := s.c.SyncState(a.Path)
+	if err != nil || !st.LocalGone.IsZero() {
+		// Never synced, or already dealt with.
+		return nil
+	}
+	remove := false
+	switch s.policy {
+	case POLICY_MIRROR:
+		remove = true
+	case POLICY_ASK:
+		remove = s.confirm(fmt.Sprintf("Remove %s / %s from the Spotify library too?", st.Artist, st.Name))
+	}
+	if remove && st.SpotifyGone.IsZero() {
+		fmt.Println("Removing", st.Artist, "/", st.Name, "from the Spotify library")
+		if err := s.library.RemoveAlbumsFromLibrary(s.ctx, st.SpotifyID); err != nil {
+			return err
+		}
+		st.SpotifyGone = time.Now()
+	}
+	st.LocalGone = time.Now()
+	return s.c.UpsertSyncState(st)
+}
+
+// checkSpotify looks for synced albums which have since been removed on
+// Spotify, dealing with them according to s.policy.
+func (s *syncer) checkSpotify() error {
+	sts, err := s.c.SyncStates()
+	if err != nil {
+		return err
+	}
+	live := make([]*cache.SyncState, 0, len(sts))
+	for _, st := range sts {
+		if st.LocalGone.IsZero() && st.SpotifyGone.IsZero() {
+			live = append(live, st)
+		}
+	}
+	for len(live) > 0 {
+		chunk := live[:min(len(live), hasChunk)]
+		live = live[len(chunk):]
+		ids := make([]spotify.ID, len(chunk))
+		for i, st := range chunk {
+			ids[i] = st.SpotifyID
+		}
+		has, err := s.library.UserHasAlbums(s.ctx, ids...)
+		if err != nil {
+			return err
+		}
+		for i, st := range chunk {
+			if has[i] {
+				st.SpotifySeen = time.Now()
+			} else if err := s.spotifyGone(st); err != nil {
+				return err
+			}
+			if err := s.c.UpsertSyncState(st); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// spotifyGone deals with a synced album no longer in the Spotify library.
+func (s *syncer) spotifyGone(st *cache.SyncState) error {
+	readd := false
+	switch s.policy {
+	case POLICY_ADD_ONLY:
+		readd = true
+	case POLICY_ASK:
+		readd = s.confirm(fmt.Sprintf("%s / %s was removed on Spotify; add it back?", st.Artist, st.Name))
+	}
+	if !readd {
+		fmt.Println("-- Removed on Spotify:", st.Artist, "/", st.Name, "at", localPath(st.Path))
+		st.SpotifyGone = time.Now()
+		return nil
+	}
+	fmt.Println("Re-adding", st.Artist, "/", st.Name)
+	if err := s.library.AddAlbumsToLibrary(s.ctx, st.SpotifyID); err != nil {
+		return err
+	}
+	st.SpotifySeen = time.Now()
+	return nil
+}
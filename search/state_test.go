@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestRemovedOnSpotify(t *testing.T) {
+	c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := &syncer{c: c}
+	then := time.Now().Add(-time.Hour)
+	cases := []struct {
+		name        string
+		localGone   time.Time
+		spotifyGone time.Time
+		want        bool
+	}{
+		{name: "synced", want: false},
+		{name: "removed on Spotify", spotifyGone: then, want: true},
+		{name: "restored locally", localGone: then, want: false},
+		{name: "restored locally after mirroring", localGone: then, spotifyGone: then, want: false},
+	}
+	for _, tc := range cases {
+		alb := &media.AlbumMetadata{Path: "music/Artist/" + tc.name, Artist: "Artist", Name: tc.name}
+		if err := c.UpsertSyncState(&cache.SyncState{Path: alb.Path, Artist: alb.Artist, Name: alb.Name, SpotifyID: "1", LocalSeen: then, LocalGone: tc.localGone, SpotifyGone: tc.spotifyGone}); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.removedOnSpotify(alb); got != tc.want {
+			t.Errorf("%s: removedOnSpotify: got %t, want %t", tc.name, got, tc.want)
+		}
+		st, err := c.SyncState(alb.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !st.LocalGone.IsZero() || !st.LocalSeen.After(then) {
+			t.Errorf("%s: state afterwards: got %+v, want seen locally", tc.name, st)
+		}
+		if got := s.removedOnSpotify(alb); got != tc.want {
+			t.Errorf("%s: removedOnSpotify again: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+// albumSearcher is the part of the Spotify client that searches for
+// albums, which tests fake.
+type albumSearcher interface {
+	Search(ctx context.Context, query string, t spotify.SearchType, opts ...spotify.RequestOption) (*spotify.SearchResult, error)
+	NextAlbumResults(ctx context.Context, s *spotify.SearchResult) error
+}
+
+// albumLibrary is the part of the Spotify client that manages the user's
+// saved albums, which tests fake.
+type albumLibrary interface {
+	UserHasAlbums(ctx context.Context, ids ...spotify.ID) ([]bool, error)
+	AddAlbumsToLibrary(ctx context.Context, ids ...spotify.ID) error
+	RemoveAlbumsFromLibrary(ctx context.Context, ids ...spotify.ID) error
+}
+
+// syncer runs local albums through search and bestMatch, adding the
+// results to the user's library.
+type syncer struct {
+	ctx    context.Context
+	client *spotify.Client
+	// searcher and library are client, but for searches and saved
+	// albums.
+	searcher albumSearcher
+	library  albumLibrary
+	c        *cache.Cache
+	reader   *bufio.Reader
+	// queue makes inexact matches get queued for a later review rather
+	// than prompting for them there and then.
+	queue bool
+	// tracks is where albums not found as a whole are synced track by
+	// track: TRACKS_PLAYLIST, TRACKS_LIKED or "" not to.
+	tracks string
+	user   string
+	// policy is what to do about albums removed from one side: one of
+	// the POLICY_ constants.
+	policy string
+	// market is the country code of the Spotify market to match albums
+	// in, or "" for any.
+	market string
+	// singles is which local albums Spotify singles and EPs may match:
+	// one of the SINGLES_ constants.
+	singles string
+	// editions are the EDITION_ preferences for choosing between
+	// editions of an album, in order.
+	editions []string
+	// depth is how many pages of search results to look through.
+	depth int
+	// queries are the query strategies to search for albums with, in
+	// order, and strategies counts the albums each has found.
+	queries    []string
+	strategies map[string]int
+}
+
+// search searches Spotify, going via the cache.
+func (s *syncer) search(text string, t spotify.SearchType) (*spotify.SearchResult, error) {
+	key := s.cacheKey(text, t)
+	results, err := s.c.Search(key)
+	if err != nil {
+		log.Println("[warn] Cache search failed:", err)
+	}
+	if results == nil && s.market != "" {
+		// Searches cached before there were markets, or without one,
+		// found albums anywhere, but list their markets for inMarket.
+		results, _ = s.c.Search(searchKey(text, t))
+	}
+	if results != nil {
+		log.Println("[info] Found results in cache")
+		return results, nil
+	}
+	log.Println("[info] Searching Spotify")
+	results, err = s.searcher.Search(s.ctx, text, t, s.marketOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.c.UpsertSearch(key, results); err != nil {
+		log.Println("[warn] Failed to upsert search into cache:", err)
+	}
+	return results, nil
+}
+
+// searchAlbums returns the albums on up to s.depth pages of results for
+// text, merged in order less duplicates. Each page goes via the cache.
+func (s *syncer) searchAlbums(text string) ([]spotify.SimpleAlbum, error) {
+	results, err := s.search(text, searchType)
+	if err != nil {
+		return nil, err
+	}
+	var pages [][]spotify.SimpleAlbum
+	for page := 0; results.Albums != nil; page++ {
+		pages = append(pages, results.Albums.Albums)
+		if page+1 >= s.depth {
+			break
+		}
+		key := pageKey(s.cacheKey(text, searchType), page+1)
+		if cached, err := s.c.Search(key); err == nil {
+			results = cached
+			continue
+		}
+		// The next page is decoded into a fresh result, as decoding
+		// reuses the slices of the one it's decoded over, albums'
+		// artists and all.
+		next := *results.Albums
+		next.Albums = nil
+		results = &spotify.SearchResult{Albums: &next}
+		err := s.searcher.NextAlbumResults(s.ctx, results)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.c.UpsertSearch(key, results); err != nil {
+			log.Println("[warn] Failed to upsert search into cache:", err)
+		}
+	}
+	return mergeAlbums(pages...), nil
+}
+
+// mergeAlbums concatenates lists of albums, keeping only the first of any
+// with the same ID.
+func mergeAlbums(lists ...[]spotify.SimpleAlbum) []spotify.SimpleAlbum {
+	seen := make(map[spotify.ID]bool)
+	albums := make([]spotify.SimpleAlbum, 0)
+	for _, l := range lists {
+		for _, al := range l {
+			if !seen[al.ID] {
+				seen[al.ID] = true
+				albums = append(albums, al)
+			}
+		}
+	}
+	return albums
+}
+
+// pageKey is the cache key for a later page of the search with key. The
+// first page, page 0, is under key itself.
+func pageKey(key string, page int) string {
+	if page == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s [page %d]", key, page+1)
+}
+
+// cacheKey is searchKey, for searches in s.market. Searches in no market
+// keep searchKey alone, as they were cached before markets.
+func (s *syncer) cacheKey(text string, t spotify.SearchType) string {
+	key := searchKey(text, t)
+	if s.market != "" {
+		key += " @" + s.market
+	}
+	return key
+}
+
+// searchKey is the cache key for a search. Album searches predate other
+// kinds, so are keyed by their text alone.
+func searchKey(text string, t spotify.SearchType) string {
+	if t == searchType {
+		return text
+	}
+	return fmt.Sprintf("[%d] %s", t, text)
+}
+
+// syncAlbum finds alb on Spotify and adds it to the library. Errors are
+// only returned for failures talking to Spotify.
+func (s *syncer) syncAlbum(alb *media.AlbumMetadata) error {
+	if s.removedOnSpotify(alb) {
+		return nil
+	}
+	artName := names.Artist(alb.Artist)
+	albName := names.Title(alb.Name)
+	if ms := s.fromDiscography(alb, artName, albName); len(ms) > 0 {
+		s.strategies[QUERY_DISCOGRAPHY]++
+		toAdd, had := s.choose([]spotify.SimpleAlbum{s.pick(alb, ms)}, true)
+		return s.addAndRecord(alb, albName, toAdd, had, QUERY_DISCOGRAPHY)
+	}
+	found, err := s.searchAlbum(alb, artName, albName)
+	if err != nil {
+		return err
+	}
+	if len(found.matches) == 0 {
+		if gone, err := s.unavailable(alb, artName, albName); err != nil || gone {
+			return err
+		}
+	}
+
+	// handle album results
+	if len(found.albums) == 0 {
+		if s.tracks != "" {
+			return s.syncTracks(alb)
+		}
+		fmt.Println("!! Failed to find", artName, "/", albName, "from", localPath(alb.Path))
+		return nil
+	}
+	albums := found.albums
+	match := found.match
+	// Without matches, albums are mere candidates, so one the user
+	// already has isn't known to be alb's counterpart, nor recorded as it.
+	matched := len(found.matches) > 0
+	if !matched {
+		log.Println("[warn] Found no good match.")
+		if s.tracks != "" {
+			return s.syncTracks(alb)
+		}
+	} else {
+		s.strategies[found.strategy]++
+		albums = []spotify.SimpleAlbum{s.pick(alb, found.matches)}
+		if match == MATCH_EXACT {
+			s.pinArtist(alb.Artist, albums[0])
+		}
+	}
+	if match != MATCH_EXACT && s.queue {
+		had, err := s.userHasAny(albums)
+		if err != nil {
+			return err
+		}
+		if had != nil {
+			if matched {
+				s.recordSync(alb, had.ID, found.strategy)
+			}
+			return nil
+		}
+		log.Println("[info] Match was not exact, so queueing for review...")
+		return s.c.UpsertReview(&cache.Review{Album: alb, Candidates: albums})
+	}
+	toAdd, had := s.choose(albums, match == MATCH_EXACT)
+	if !matched {
+		had = nil
+	}
+	return s.addAndRecord(alb, albName, toAdd, had, found.strategy)
+}
+
+// addAndRecord adds toAdd, recording it, or else the album the user
+// already had, as alb's Spotify counterpart, found by strategy.
+func (s *syncer) addAndRecord(alb *media.AlbumMetadata, albName string, toAdd []spotify.SimpleAlbum, had *spotify.SimpleAlbum, strategy string) error {
+	if err := s.add(albName, toAdd); err != nil {
+		return err
+	}
+	switch {
+	case had != nil:
+		s.recordSync(alb, had.ID, strategy)
+	case len(toAdd) > 0:
+		s.recordSync(alb, toAdd[0].ID, strategy)
+	}
+	return nil
+}
+
+// userHasAny returns whichever of albums the user already has, if any.
+func (s *syncer) userHasAny(albums []spotify.SimpleAlbum) (*spotify.SimpleAlbum, error) {
+	for _, item := range albums {
+		has, err := s.library.UserHasAlbums(s.ctx, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		if has[0] {
+			fmt.Println("user already has ", item.Artists[0].Name, "/", item.Name, "considered a match")
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+// choose picks which of albums to add, prompting unless exact. If the user
+// already has one of them, it's returned instead.
+func (s *syncer) choose(albums []spotify.SimpleAlbum, exact bool) ([]spotify.SimpleAlbum, *spotify.SimpleAlbum) {
+	toAdd := make([]spotify.SimpleAlbum, 0)
+	fmt.Println("Albums:")
+	for _, item := range albums {
+
+		fmt.Println("   ", item.Name)
+		fmt.Println("    >> Artists:")
+		for _, artist := range item.Artists {
+			fmt.Println("        ", artist.Name)
+		}
+		has, err := s.library.UserHasAlbums(s.ctx, item.ID)
+		if err != nil {
+			fmt.Println("err:", err)
+			continue
+		}
+		if has[0] {
+			fmt.Println("user already has ", item.Artists[0].Name, "/", item.Name, "considered a match")
+			return toAdd, &item
+		}
+		if exact {
+			toAdd = append(toAdd, item)
+		} else {
+			log.Println("[info] Match was not exact, so prompting...")
+			fa, err := s.album(item.ID)
+			if err != nil {
+				log.Println("[warn] Failed to get album, so skipping it:", err)
+				continue
+			}
+			fmt.Println("    >> Tracks:")
+			for _, track := range fa.Tracks.Tracks { // Assume just 1 page
+				fmt.Println("        ", track.Name)
+			}
+			fmt.Print("Add to library? [y/N] => ")
+			r, _ := s.reader.ReadString('\n')
+			r = strings.TrimSpace(r)
+			if r == "y" || r == "Y" {
+				toAdd = append(toAdd, item)
+				break
+			}
+		}
+	}
+	return toAdd, nil
+}
+
+func (s *syncer) add(albName string, toAdd []spotify.SimpleAlbum) error {
+	if len(toAdd) == 0 {
+		return nil
+	}
+	fmt.Println("Adding...")
+	ids := make([]spotify.ID, len(toAdd))
+	for i, alb := range toAdd {
+		fmt.Println("    ", alb.Artists[0].Name, " / ", albName)
+		ids[i] = alb.ID
+	}
+	return s.library.AddAlbumsToLibrary(s.ctx, ids...)
+}
+
+// review prompts for each of the queued reviews in turn.
+func (s *syncer) review() error {
+	rs, err := s.c.Reviews()
+	if err != nil {
+		return err
+	}
+	fmt.Println(len(rs), "albums to review")
+	for _, r := range rs {
+		fmt.Println(">> Reviewing", r.Album.Artist, "/", r.Album.Name)
+		if len(r.Tracks) > 0 {
+			err = s.addTracks(r.Album, s.chooseTracks(r.Tracks))
+		} else {
+			// Candidates needn't have matched, so only what's
+			// chosen is recorded.
+			toAdd, _ := s.choose(r.Candidates, false)
+			err = s.addAndRecord(r.Album, r.Album.Name, toAdd, nil, "")
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.c.DeleteReview(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+// fakeLibrary is a library of saved albums, held in memory.
+type fakeLibrary map[spotify.ID]bool
+
+func (f fakeLibrary) UserHasAlbums(ctx context.Context, ids ...spotify.ID) ([]bool, error) {
+	has := make([]bool, len(ids))
+	for i, id := range ids {
+		has[i] = f[id]
+	}
+	return has, nil
+}
+
+func (f fakeLibrary) AddAlbumsToLibrary(ctx context.Context, ids ...spotify.ID) error {
+	for _, id := range ids {
+		f[id] = true
+	}
+	return nil
+}
+
+func (f fakeLibrary) RemoveAlbumsFromLibrary(ctx context.Context, ids ...spotify.ID) error {
+	for _, id := range ids {
+		delete(f, id)
+	}
+	return nil
+}
+
+func TestSyncAlbumOwnedCandidate(t *testing.T) {
+	for _, queue := range []bool{false, true} {
+		c, err := cache.New(t.TempDir()+"/test.db", cache.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		s := &syncer{
+			ctx: context.Background(),
+			searcher: &fakeSearcher{pages: []string{
+				`{"albums": {"items": [{"id": "1", "name": "Something Else", "album_type": "album", "artists": [{"name": "Someone Else"}]}]}}`,
+			}},
+			library:    fakeLibrary{"1": true},
+			c:          c,
+			reader:     bufio.NewReader(strings.NewReader("")),
+			queue:      queue,
+			depth:      1,
+			queries:    []string{QUERY_PLAIN},
+			strategies: make(map[string]int),
+		}
+		alb := &media.AlbumMetadata{Path: "music/Artist/Album", Artist: "Artist", Name: "Album"}
+		if err := s.syncAlbum(alb); err != nil {
+			t.Fatalf("queue %t: syncAlbum: %v", queue, err)
+		}
+		st, err := c.SyncState(alb.Path)
+		if err == nil && st != nil {
+			t.Errorf("queue %t: sync state: got %+v, want none for an unmatched album", queue, st)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/cache"
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	// maxTrackCandidates bounds how many search results are offered for
+	// a track with no good match.
+	maxTrackCandidates = 5
+	// likedChunk is the most tracks Spotify accepts in a single request
+	// to check or add to Liked Songs.
+	likedChunk = 50
+)
+
+// bestTracks is bestMatches for tracks, ordered by how close their
+// durations are to t's.
+func bestTracks(artistName string, t media.Track, tracks []spotify.FullTrack) ([]spotify.FullTrack, int) {
+	ms, match := bestMatchesBy(artistName, t.Title, tracks, func(ft spotify.FullTrack) (string, []spotify.SimpleArtist) {
+		return ft.Name, ft.Artists
+	})
+	if t.Duration == 0 {
+		return ms, match
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		return durationDiff(t, ms[i]) < durationDiff(t, ms[j])
+	})
+	return ms, match
+}
+
+func durationDiff(t media.Track, ft spotify.FullTrack) time.Duration {
+	return (t.Duration - time.Duration(ft.Duration)*time.Millisecond).Abs()
+}
+
+// matchTrack searches Spotify for a single track of alb. Tracks are only
+// exact matches if their durations agree too, where known.
+func (s *syncer) matchTrack(alb *media.AlbumMetadata, t media.Track) (cache.TrackReview, error) {
+	tr := cache.TrackReview{Track: t}
+	artist := t.Artist
+	if artist == "" && alb.Artist != media.VariousArtists {
+		artist = alb.Artist
+	}
+	artist = names.Artist(artist)
+	text := strings.TrimSpace(fmt.Sprintf("%s %s", artist, names.Title(t.Title)))
+	results, err := s.search(text, spotify.SearchTypeTrack)
+	if err != nil {
+		return tr, err
+	}
+	if results.Tracks == nil || len(results.Tracks.Tracks) == 0 {
+		return tr, nil
+	}
+	ms, match := bestTracks(artist, t, results.Tracks.Tracks)
+	if len(ms) == 0 {
+		tr.Candidates = results.Tracks.Tracks[:min(len(results.Tracks.Tracks), maxTrackCandidates)]
+		return tr, nil
+	}
+	tr.Candidates = ms
+	tr.Exact = match == MATCH_EXACT && (t.Duration == 0 || durationDiff(t, ms[0]) <= *toleranceFlag)
+	return tr, nil
+}
+
+// syncTracks is the fallback for albums which can't be found as a whole:
+// it finds each track individually and adds them to s.tracks.
+func (s *syncer) syncTracks(alb *media.AlbumMetadata) error {
+	fmt.Println(">> Searching track by track for", alb.Artist, "/", alb.Name)
+	trs := make([]cache.TrackReview, 0, len(alb.Tracks))
+	exact := true
+	for _, t := range alb.Tracks {
+		if t.Placeholder {
+			continue
+		}
+		tr, err := s.matchTrack(alb, t)
+		if err != nil {
+			return err
+		}
+		if len(tr.Candidates) == 0 {
+			fmt.Println("!! Failed to find", t.Title, "from", localPath(path.Join(alb.Path, t.Filename)))
+			continue
+		}
+		exact = exact && tr.Exact
+		trs = append(trs, tr)
+	}
+	if len(trs) == 0 {
+		return nil
+	}
+	if !exact && s.queue {
+		log.Println("[info] Some tracks were not exact matches, so queueing for review...")
+		return s.c.UpsertReview(&cache.Review{Album: alb, Tracks: trs})
+	}
+	return s.addTracks(alb, s.chooseTracks(trs))
+}
+
+// chooseTracks picks a Spotify track for each of trs, prompting for those
+// which aren't exact.
+func (s *syncer) chooseTracks(trs []cache.TrackReview) []spotify.FullTrack {
+	chosen := make([]spotify.FullTrack, 0, len(trs))
+	for _, tr := range trs {
+		if tr.Exact {
+			chosen = append(chosen, tr.Candidates[0])
+			continue
+		}
+		fmt.Println("Track:", tr.Track.Title, tr.Track.Duration.Round(time.Second))
+		for i, ft := range tr.Candidates {
+			artists := make([]string, len(ft.Artists))
+			for j, ar := range ft.Artists {
+				artists[j] = ar.Name
+			}
+			d := time.Duration(ft.Duration) * time.Millisecond
+			fmt.Printf("    %d) %s / %s (%s) %s\n", i+1, strings.Join(artists, ", "), ft.Name, ft.Album.Name, d.Round(time.Second))
+		}
+		fmt.Printf("Add which? [1-%d, blank for none] => ", len(tr.Candidates))
+		r, _ := s.reader.ReadString('\n')
+		n, err := strconv.Atoi(strings.TrimSpace(r))
+		if err != nil || n < 1 || n > len(tr.Candidates) {
+			continue
+		}
+		chosen = append(chosen, tr.Candidates[n-1])
+	}
+	return chosen
+}
+
+// addTracks adds the tracks found for alb to s.tracks. Reviews may be of
+// tracks queued by an earlier run, so these go to a playlist if no
+// destination was given this time.
+func (s *syncer) addTracks(alb *media.AlbumMetadata, tracks []spotify.FullTrack) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ids := make([]spotify.ID, 0, len(tracks))
+	seen := make(map[spotify.ID]bool)
+	for _, ft := range tracks {
+		if !seen[ft.ID] {
+			seen[ft.ID] = true
+			ids = append(ids, ft.ID)
+		}
+	}
+	if s.tracks == TRACKS_LIKED {
+		return s.like(ids)
+	}
+	name := alb.Artist + " - " + alb.Name
+	fmt.Println("Adding", len(ids), "tracks to playlist", name)
+	return s.setPlaylist(name, "Synced from "+localPath(alb.Path), ids)
+}
+
+// like adds whichever of ids aren't already in Liked Songs.
+func (s *syncer) like(ids []spotify.ID) error {
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), likedChunk)]
+		ids = ids[len(chunk):]
+		has, err := s.client.UserHasTracks(s.ctx, chunk...)
+		if err != nil {
+			return err
+		}
+		toAdd := make([]spotify.ID, 0, len(chunk))
+		for i, id := range chunk {
+			if !has[i] {
+				toAdd = append(toAdd, id)
+			}
+		}
+		if len(toAdd) == 0 {
+			continue
+		}
+		fmt.Println("Adding", len(toAdd), "tracks to Liked Songs")
+		if err := s.client.AddTracksToLibrary(s.ctx, toAdd...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+const (
+	// SINGLES_NEVER never matches local albums with Spotify singles,
+	// which is what Spotify calls EPs too.
+	SINGLES_NEVER = "never"
+	// SINGLES_SHORT matches them only with local albums of up to
+	// epTracks tracks.
+	SINGLES_SHORT = "short"
+	// SINGLES_ALWAYS matches them with any local album, though still
+	// preferring albums.
+	SINGLES_ALWAYS = "always"
+)
+
+const (
+	// epTracks is the most tracks a local album can have and still be
+	// taken for a single or EP.
+	epTracks = 6
+	// minOverlap is the fraction of a local compilation's tracks a
+	// Spotify album must share to be taken for it.
+	minOverlap = 0.5
+)
+
+// allowType reports whether al is of a type which may match alb.
+func (s *syncer) allowType(alb *media.AlbumMetadata, al spotify.SimpleAlbum) bool {
+	if al.AlbumType != "single" {
+		return true
+	}
+	switch s.singles {
+	case SINGLES_ALWAYS:
+		return true
+	case SINGLES_SHORT:
+		return len(alb.Tracks) > 0 && len(alb.Tracks) <= epTracks
+	}
+	return false
+}
+
+// byType drops those of albums, candidates for alb, which are of the wrong
+// type, and orders singles after the rest.
+func (s *syncer) byType(alb *media.AlbumMetadata, albums []spotify.SimpleAlbum) []spotify.SimpleAlbum {
+	ms := make([]spotify.SimpleAlbum, 0, len(albums))
+	for _, al := range albums {
+		if s.allowType(alb, al) {
+			ms = append(ms, al)
+		} else {
+			debug("%s / %s is a single\n", al.ID, al.Name)
+		}
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].AlbumType != "single" && ms[j].AlbumType == "single"
+	})
+	return ms
+}
+
+// matchAlbum returns the best matches for alb, with names artName and
+// albName, among albums. Albums of the wrong type are dropped first, so
+// that they can't stand in the way of worse matches of the right type.
+func (s *syncer) matchAlbum(alb *media.AlbumMetadata, artName string, albName string, albums []spotify.SimpleAlbum) ([]spotify.SimpleAlbum, int) {
+	if alb.Artist == media.VariousArtists {
+		return s.compilationMatches(alb, albName, albums)
+	}
+	return bestMatches(artName, albName, s.byType(alb, albums))
+}
+
+// compilationMatches matches a local compilation by name alone, since
+// Spotify credits compilations to their tracks' artists, and then by how
+// many tracks are shared, best first. Matches are only exact if enough
+// tracks are known to be shared.
+func (s *syncer) compilationMatches(alb *media.AlbumMetadata, albName string, albums []spotify.SimpleAlbum) ([]spotify.SimpleAlbum, int) {
+	ms, match := bestMatchesBy("", albName, albums, func(al spotify.SimpleAlbum) (string, []spotify.SimpleArtist) {
+		return al.Name, []spotify.SimpleArtist{{}}
+	})
+	overlaps := make(map[spotify.ID]float64)
+	known := false
+	kept := make([]spotify.SimpleAlbum, 0, len(ms))
+	for _, al := range ms {
+		fa, err := s.album(al.ID)
+		if err != nil {
+			log.Println("[warn] Failed to get album for track check:", err)
+			return nil, MATCH_UNKNOWN
+		}
+		o, ok := trackOverlap(alb.Tracks, fa.Tracks.Tracks)
+		if ok && o < minOverlap {
+			debug("%s / %s shares only %.2f of the tracks\n", al.ID, al.Name, o)
+			continue
+		}
+		known = known || ok
+		overlaps[al.ID] = o
+		kept = append(kept, al)
+	}
+	if len(kept) == 0 {
+		return nil, MATCH_UNKNOWN
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return overlaps[kept[i].ID] > overlaps[kept[j].ID]
+	})
+	if match == MATCH_EXACT && !known {
+		match = MATCH_SRC_PREFIX
+	}
+	return kept, match
+}
+
+// trackOverlap returns the fraction of local's tracks with a title among
+// remote's, and whether it's known: tracks with placeholder titles aren't
+// counted.
+func trackOverlap(local []media.Track, remote []spotify.SimpleTrack) (float64, bool) {
+	titles := make([]string, 0, len(remote))
+	for _, t := range remote {
+		titles = append(titles, titleKeys(t.Name)...)
+	}
+	n, shared := 0, 0
+	for _, t := range local {
+		if t.Placeholder || t.Title == "" {
+			continue
+		}
+		n++
+		if anyKey(titleKeys(t.Title), titles, equal) {
+			shared++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return float64(shared) / float64(n), true
+}
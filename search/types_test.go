@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/tschroed/spotsync/media"
+)
+
+func TestByType(t *testing.T) {
+	single := spotify.SimpleAlbum{ID: "single", AlbumType: "single"}
+	album := spotify.SimpleAlbum{ID: "album", AlbumType: "album"}
+	comp := spotify.SimpleAlbum{ID: "comp", AlbumType: "compilation"}
+	short := &media.AlbumMetadata{Tracks: make([]media.Track, 4)}
+	long := &media.AlbumMetadata{Tracks: make([]media.Track, 12)}
+	cases := []struct {
+		singles string
+		alb     *media.AlbumMetadata
+		want    []spotify.SimpleAlbum
+	}{
+		{singles: SINGLES_SHORT, alb: long, want: []spotify.SimpleAlbum{album, comp}},
+		{singles: SINGLES_SHORT, alb: short, want: []spotify.SimpleAlbum{album, comp, single}},
+		{singles: SINGLES_NEVER, alb: short, want: []spotify.SimpleAlbum{album, comp}},
+		{singles: SINGLES_ALWAYS, alb: long, want: []spotify.SimpleAlbum{album, comp, single}},
+	}
+	for _, tc := range cases {
+		s := &syncer{singles: tc.singles}
+		got := s.byType(tc.alb, []spotify.SimpleAlbum{single, album, comp})
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("byType(%s, %d tracks) -want, +got: %s", tc.singles, len(tc.alb.Tracks), diff)
+		}
+	}
+}
+
+func TestMatchAlbumSkipsSingles(t *testing.T) {
+	artists := []spotify.SimpleArtist{{ID: "artist1", Name: "Artist"}}
+	single := spotify.SimpleAlbum{ID: "single", Name: "Lovesick", AlbumType: "single", Artists: artists}
+	album := spotify.SimpleAlbum{ID: "album", Name: "Lovesick Sessions", AlbumType: "album", Artists: artists}
+	alb := &media.AlbumMetadata{Artist: "Artist", Name: "Lovesick", Tracks: make([]media.Track, 12)}
+	s := &syncer{singles: SINGLES_SHORT}
+	ms, match := s.matchAlbum(alb, "Artist", "Lovesick", []spotify.SimpleAlbum{single, album})
+	if diff := cmp.Diff([]spotify.SimpleAlbum{album}, ms); diff != "" || match != MATCH_SRC_PREFIX {
+		t.Errorf("matchAlbum: got match %d, want %d; -want, +got: %s", match, MATCH_SRC_PREFIX, diff)
+	}
+}
+
+func TestTrackOverlap(t *testing.T) {
+	remote := []spotify.SimpleTrack{{Name: "One"}, {Name: "Two - Remastered 2011"}, {Name: "Three"}}
+	cases := []struct {
+		local     []media.Track
+		want      float64
+		wantKnown bool
+	}{
+		{local: []media.Track{{Title: "One"}, {Title: "Two"}, {Title: "Four"}, {Title: "Five"}}, want: 0.5, wantKnown: true},
+		{local: []media.Track{{Title: "One"}, {Title: "Track 02", Placeholder: true}}, want: 1, wantKnown: true},
+		{local: []media.Track{{Title: "Track 01", Placeholder: true}}, wantKnown: false},
+	}
+	for _, tc := range cases {
+		got, known := trackOverlap(tc.local, remote)
+		if got != tc.want || known != tc.wantKnown {
+			t.Errorf("trackOverlap(%v): got %.2f, %t, want %.2f, %t", tc.local, got, known, tc.want, tc.wantKnown)
+		}
+	}
+}
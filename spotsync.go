@@ -3,11 +3,70 @@ package spotsync
 import (
 	"strings"
 	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
 )
 
+// CanonOptions says how CanonicalizeNameWith normalizes a name before
+// dropping punctuation, spaces and symbols. The zero value normalizes
+// nothing, which is how CanonicalizeName used to behave.
+type CanonOptions struct {
+	// Decompose applies NFKD, splitting precomposed letters from their
+	// diacritics and folding compatibility forms like "ﬁ" to "fi".
+	Decompose bool
+	// FoldWidth folds full-width and half-width forms to their usual
+	// widths, for when Decompose doesn't.
+	FoldWidth bool
+	// KeepMarks keeps diacritics, which are otherwise dropped from
+	// Latin, Greek and Cyrillic letters: "é" is only "e" with Decompose
+	// and without KeepMarks. Marks on other letters, like the dakuten
+	// telling "バ" from "ハ", are always kept with Decompose.
+	KeepMarks bool
+	// FoldCase uses Unicode case folding, so "ß" matches "ss", rather
+	// than lowercasing.
+	FoldCase bool
+}
+
+var (
+	// DefaultCanon is what CanonicalizeName uses. It may be set to
+	// LegacyCanon, before any names are compared, for the old behaviour.
+	DefaultCanon = CanonOptions{Decompose: true, FoldWidth: true, FoldCase: true}
+	// LegacyCanon is CanonicalizeName's original behaviour, which only
+	// drops diacritics already separate from their letters.
+	LegacyCanon = CanonOptions{}
+)
+
+// CanonicalizeName reduces name to a key for comparing names, with
+// DefaultCanon.
 func CanonicalizeName(name string) string {
+	return CanonicalizeNameWith(name, DefaultCanon)
+}
+
+// CanonicalizeNameWith reduces name to a key for comparing names, with
+// options o.
+func CanonicalizeNameWith(name string, o CanonOptions) string {
+	if o.Decompose {
+		name = norm.NFKD.String(name)
+	}
+	if o.FoldWidth {
+		name = width.Fold.String(name)
+	}
+	if o.FoldCase {
+		name = cases.Fold().String(name)
+	}
 	var b strings.Builder
+	// base is the letter any marks are on.
+	var base rune
 	for _, r := range name {
+		if unicode.IsMark(r) && (o.KeepMarks || o.Decompose && !unicode.In(base, unicode.Latin, unicode.Greek, unicode.Cyrillic)) {
+			b.WriteRune(r)
+			continue
+		}
+		if !unicode.IsMark(r) {
+			base = r
+		}
 		// Contortions because Kanji, etc. are "other" and there's no tidy
 		// test for "word characters".
 		if unicode.IsControl(r) || unicode.IsMark(r) || unicode.IsPunct(r) || unicode.IsSpace(r) || unicode.IsSymbol(r) {
@@ -15,5 +74,10 @@ func CanonicalizeName(name string) string {
 		}
 		b.WriteRune(unicode.ToLower(r))
 	}
-	return b.String()
+	s := b.String()
+	if o.Decompose {
+		// Put kept marks back on their letters.
+		s = norm.NFC.String(s)
+	}
+	return s
 }
@@ -29,12 +29,48 @@ func TestCanonicalizeName(t *testing.T) {
 		},
 		{
 			input: "Carta de conduçao (Butterkeks",
-			want:  "cartadeconduçaobutterkeks",
+			want:  "cartadeconducaobutterkeks",
+		},
+		{
+			input: "Carta de condução",
+			want:  "cartadeconducao",
+		},
+		{
+			input: "Ｆｕｌｌ Ｗｉｄｔｈ",
+			want:  "fullwidth",
+		},
+		{
+			input: "Deﬁnitely Maybe",
+			want:  "definitelymaybe",
+		},
+		{
+			input: "Straße",
+			want:  "strasse",
+		},
+		{
+			input: "ΣΊΣΥΦΟΣ",
+			want:  "σισυφοσ",
+		},
+		{
+			input: "ｶﾀｶﾅ",
+			want:  "カタカナ",
 		},
 		{
 			input: "䩄䬠湥慴潲",
 			want:  "䩄䬠湥慴潲",
 		},
+		{
+			input: "バンド",
+			want:  "バンド",
+		},
+		{
+			input: "きゃりーぱみゅぱみゅ",
+			want:  "きゃりーぱみゅぱみゅ",
+		},
+		{
+			input: "ﾊﾞﾝﾄﾞ",
+			want:  "バンド",
+		},
 	}
 
 	for _, tc := range cases {
@@ -43,3 +79,43 @@ func TestCanonicalizeName(t *testing.T) {
 		}
 	}
 }
+
+func TestCanonicalizeNameWith(t *testing.T) {
+	cases := []struct {
+		input string
+		opts  spotsync.CanonOptions
+		want  string
+	}{
+		{
+			input: "Carta de conduçao",
+			opts:  spotsync.LegacyCanon,
+			want:  "cartadeconduçao",
+		},
+		{
+			input: "Carta de conduc\u0327ao",
+			opts:  spotsync.LegacyCanon,
+			want:  "cartadeconducao",
+		},
+		{
+			input: "Straße",
+			opts:  spotsync.LegacyCanon,
+			want:  "straße",
+		},
+		{
+			input: "Beyoncé",
+			opts:  spotsync.CanonOptions{Decompose: true, KeepMarks: true},
+			want:  "beyoncé",
+		},
+		{
+			input: "Ｂｅｙｏｎｃé",
+			opts:  spotsync.CanonOptions{FoldWidth: true},
+			want:  "beyoncé",
+		},
+	}
+
+	for _, tc := range cases {
+		if c := spotsync.CanonicalizeNameWith(tc.input, tc.opts); c != tc.want {
+			t.Errorf("CanonicalizeNameWith(%v, %+v): got %s, want %s", tc.input, tc.opts, c, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package spotsync
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// kana romanizes hiragana by Hepburn; katakana are looked up as
+	// their hiragana.
+	kana = map[rune]string{
+		'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+		'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+		'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+		'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+		'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+		'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+		'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+		'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+		'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+		'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+		'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+		'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+		'や': "ya", 'ゆ': "yu", 'よ': "yo",
+		'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+		'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	}
+	// smallKana modify the kana before them: "きゃ" is "kya", "ふぁ" is
+	// "fa".
+	smallKana = map[rune]string{
+		'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+		'ゃ': "a", 'ゅ': "u", 'ょ': "o", 'ゎ': "a",
+	}
+
+	cyrillic = map[rune]string{
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e",
+		'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k",
+		'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+		'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+		'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+		'э': "e", 'ю': "yu", 'я': "ya",
+		// Ukrainian, Belarusian and Serbian.
+		'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u", 'ђ': "dj",
+		'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	}
+
+	greek = map[rune]string{
+		'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z",
+		'η': "i", 'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m",
+		'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s",
+		'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+		'ω': "o",
+	}
+)
+
+// Transliterate writes Japanese kana, Cyrillic and Greek in Latin letters,
+// leaving anything else be. Kanji are left as they are, since reading them
+// takes a dictionary. Long vowel marks are dropped, since romanizations
+// spell them too many ways to guess.
+func Transliterate(s string) string {
+	var b strings.Builder
+	// Compose first, so voiced kana are single runes.
+	rs := []rune(norm.NFC.String(s))
+	double := false
+	for i := 0; i < len(rs); i++ {
+		r := unicode.ToLower(rs[i])
+		if r >= 'ァ' && r <= 'ヶ' {
+			r -= 'ァ' - 'ぁ'
+		}
+		switch {
+		case r == 'ー':
+			continue
+		case r == 'っ':
+			// Sokuon doubles the next consonant.
+			double = true
+			continue
+		case smallKana[r] != "":
+			small(&b, smallKana[r])
+			continue
+		}
+		lat, ok := latin(r)
+		if !ok {
+			b.WriteRune(rs[i])
+			double = false
+			continue
+		}
+		if r == 'ο' && i+1 < len(rs) && strings.HasPrefix(norm.NFD.String(string(unicode.ToLower(rs[i+1]))), "υ") {
+			lat = "ou"
+			i++
+		}
+		if double && lat != "" {
+			if strings.HasPrefix(lat, "ch") {
+				b.WriteByte('t')
+			} else {
+				b.WriteByte(lat[0])
+			}
+		}
+		double = false
+		b.WriteString(lat)
+	}
+	return b.String()
+}
+
+// latin looks r up in the tables, by its base letter if it has marks.
+func latin(r rune) (string, bool) {
+	for _, m := range []map[rune]string{kana, cyrillic, greek} {
+		if lat, ok := m[r]; ok {
+			return lat, true
+		}
+	}
+	// Greek tonos and the like.
+	if d := []rune(norm.NFD.String(string(r))); len(d) > 1 {
+		if lat, ok := greek[d[0]]; ok {
+			return lat, true
+		}
+	}
+	return "", false
+}
+
+// small applies a small kana's vowel to what's been written so far.
+func small(b *strings.Builder, vowel string) {
+	s := b.String()
+	switch {
+	case strings.HasSuffix(s, "shi"), strings.HasSuffix(s, "chi"), strings.HasSuffix(s, "ji"):
+		// "しゃ" is "sha", not "shya".
+		s = s[:len(s)-1] + vowel
+	case strings.HasSuffix(s, "i") && len(s) > 1 && !strings.ContainsAny(s[len(s)-2:len(s)-1], "aeiou"):
+		// "きゃ" is "kya".
+		s = s[:len(s)-1] + "y" + vowel
+	case len(s) > 1 && strings.ContainsAny(s[len(s)-1:], "aeiou") && !strings.ContainsAny(s[len(s)-2:len(s)-1], "aeiou"):
+		// "ふぁ" is "fa", "てぃ" is "ti".
+		s = s[:len(s)-1] + vowel
+	case strings.HasSuffix(s, "u"):
+		// "うぃ" is "wi".
+		s = s[:len(s)-1] + "w" + vowel
+	default:
+		s += vowel
+	}
+	b.Reset()
+	b.WriteString(s)
+}
+
+// CanonicalKeys returns the keys name may be matched by: its canonical
+// form, and if different, that of its transliteration.
+func CanonicalKeys(name string) []string {
+	keys := []string{CanonicalizeName(name)}
+	if t := CanonicalizeName(Transliterate(name)); t != keys[0] {
+		keys = append(keys, t)
+	}
+	return keys
+}
@@ -0,0 +1,43 @@
+package spotsync_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tschroed/spotsync"
+)
+
+func TestTransliterate(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{input: "きゃりーぱみゅぱみゅ", want: "kyaripamyupamyu"},
+		{input: "サカナクション", want: "sakanakushon"},
+		{input: "ファンファーレ", want: "fanfare"},
+		{input: "ちょっと", want: "chotto"},
+		{input: "マッチ", want: "matchi"},
+		{input: "しゃしん", want: "shashin"},
+		{input: "ゆらゆら帝国", want: "yurayura帝国"},
+		{input: "Кино", want: "kino"},
+		{input: "Сплин", want: "splin"},
+		{input: "Жуки", want: "zhuki"},
+		{input: "Μίκης Θεοδωράκης", want: "mikis theodorakis"},
+		{input: "Μουσική", want: "mousiki"},
+		{input: "Radiohead", want: "Radiohead"},
+	}
+	for _, tc := range cases {
+		if got := spotsync.Transliterate(tc.input); got != tc.want {
+			t.Errorf("Transliterate(%q): got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestCanonicalKeys(t *testing.T) {
+	if got, want := spotsync.CanonicalKeys("Kino"), []string{"kino"}; !slices.Equal(got, want) {
+		t.Errorf("CanonicalKeys(Kino): got %q, want %q", got, want)
+	}
+	if got, want := spotsync.CanonicalKeys("Кино"), []string{"кино", "kino"}; !slices.Equal(got, want) {
+		t.Errorf("CanonicalKeys(Кино): got %q, want %q", got, want)
+	}
+}